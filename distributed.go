@@ -0,0 +1,528 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	gurl "net/url"
+	"strings"
+	"sync"
+	"time"
+
+	lbstress "github.com/wenjiax/stress/stress"
+)
+
+const (
+	// agentStartDelay is the time between the start of the controller and the
+	// synchronized start of the agents, for all agents to get their part before it.
+	agentStartDelay = time.Second
+	// collectMargin is the time the controller starts collecting before the agents
+	// start, so that the first results are not before the start of the task.
+	collectMargin = 50 * time.Millisecond
+)
+
+type (
+	// agentPlan is the part of a task sent by the controller to an agent.
+	agentPlan struct {
+		Start      time.Time                `json:"start"`
+		Number     int                      `json:"number"`
+		Concurrent int                      `json:"concurrent"`
+		Duration   time.Duration            `json:"duration"`
+		Rate       int                      `json:"rate"`
+		Stages     []lbstress.Stage         `json:"stages"`
+		Rows       []map[string]interface{} `json:"rows"`
+		DataMode   string                   `json:"dataMode"`
+		Cookies    string                   `json:"cookies"`
+		Mix        bool                     `json:"mix"`
+		Scenarios  []*agentScenario         `json:"scenarios"`
+	}
+	// agentScenario is a transaction of the plan.
+	agentScenario struct {
+		Name     string          `json:"name"`
+		Weight   int             `json:"weight"`
+		Requests []*agentRequest `json:"requests"`
+	}
+	// agentRequest is a request of a transaction, with the request options of the task.
+	agentRequest struct {
+		Name               string                `json:"name"`
+		URL                string                `json:"url"`
+		Method             string                `json:"method"`
+		Header             http.Header           `json:"header"`
+		Body               []byte                `json:"body"`
+		Extractors         []*lbstress.Extractor `json:"extractors"`
+		Checks             []*lbstress.Check     `json:"checks"`
+		Timeout            int                   `json:"timeout"`
+		ThinkTime          int                   `json:"thinkTime"`
+		ProxyAddr          string                `json:"proxyAddr"`
+		Host               string                `json:"host"`
+		H2                 bool                  `json:"h2"`
+		DisableCompression bool                  `json:"disableCompression"`
+		DisableKeepAlives  bool                  `json:"disableKeepAlives"`
+		DisableRedirects   bool                  `json:"disableRedirects"`
+		AbortOnFailure     bool                  `json:"abortOnFailure"`
+		Repeat             int                   `json:"repeat"`
+	}
+	// agentMessage is a line of the response of an agent,
+	// the result of a transaction or the error that ended the run.
+	agentMessage struct {
+		Result *agentResult `json:"result,omitempty"`
+		Err    string       `json:"err,omitempty"`
+	}
+	// agentResult is the result of a transaction, its start times are offsets from the start of the plan.
+	agentResult struct {
+		Start             time.Duration  `json:"start"`
+		Duration          time.Duration  `json:"duration"`
+		CorrectedDuration time.Duration  `json:"correctedDuration"`
+		Stage             int            `json:"stage"`
+		Scenario          int            `json:"scenario"`
+		Aborted           bool           `json:"aborted,omitempty"`
+		AbortReason       string         `json:"abortReason,omitempty"`
+		Details           []*agentDetail `json:"details"`
+	}
+	// agentDetail is the result of a request.
+	agentDetail struct {
+		Step              int           `json:"step"`
+		URL               string        `json:"url"`
+		Method            string        `json:"method"`
+		Start             time.Duration `json:"start"`
+		Err               string        `json:"err,omitempty"`
+		StatusCode        int           `json:"statusCode"`
+		Duration          time.Duration `json:"duration"`
+		ConnDuration      time.Duration `json:"connDuration"`
+		DNSDuration       time.Duration `json:"dnsDuration"`
+		ReqDuration       time.Duration `json:"reqDuration"`
+		ResDuration       time.Duration `json:"resDuration"`
+		DelayDuration     time.Duration `json:"delayDuration"`
+		ReqBeforeDuration time.Duration `json:"reqBeforeDuration"`
+		ResAfterDuration  time.Duration `json:"resAfterDuration"`
+		ContentLength     int64         `json:"contentLength"`
+		Checks            []bool        `json:"checks"`
+		Skipped           bool          `json:"skipped,omitempty"`
+	}
+)
+
+// runController divides the task between the agents, starts them in sync and
+// merges the results they send into the report of the task. It stops the agents
+// when the task is stopped before them, such as when it is aborted.
+func runController(ctx context.Context, task *lbstress.Task, scenarios []*lbstress.Scenario, mix bool, agents []string) (*lbstress.RunResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	start := time.Now().Add(agentStartDelay)
+	var plans []*agentPlan
+	for i := range agents {
+		plan, err := newAgentPlan(task, scenarios, mix, i, len(agents), start)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, plan)
+	}
+	results := make(chan *lbstress.Result, 1024)
+	var wg sync.WaitGroup
+	var mx sync.Mutex
+	var agentErr error
+	for i, addr := range agents {
+		wg.Add(1)
+		go func(addr string, plan *agentPlan) {
+			defer wg.Done()
+			if err := runAgent(ctx, addr, plan, results); err != nil && ctx.Err() == nil {
+				mx.Lock()
+				if agentErr == nil {
+					agentErr = fmt.Errorf("agent %v: %v", addr, err)
+				}
+				mx.Unlock()
+				cancel()
+			}
+		}(addr, plans[i])
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	// Start collecting just before the start of the agents.
+	timer := time.NewTimer(start.Add(-collectMargin).Sub(time.Now()))
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		timer.Stop()
+	}
+	var result *lbstress.RunResult
+	var err error
+	if mix {
+		result, err = task.CollectMix(ctx, results, scenarios...)
+	} else {
+		result, err = task.Collect(ctx, results, scenarios[0].Configs...)
+	}
+	cancel()
+	wg.Wait()
+	mx.Lock()
+	defer mx.Unlock()
+	if agentErr != nil {
+		return result, agentErr
+	}
+	return result, err
+}
+
+// runAgent sends the plan to the agent at addr, and sends the results of the agent to results.
+func runAgent(ctx context.Context, addr string, plan *agentPlan, results chan<- *lbstress.Result) error {
+	b, err := json.Marshal(plan)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	req, err := http.NewRequest("POST", strings.TrimSuffix(addr, "/")+"/run", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(body)))
+	}
+	dec := json.NewDecoder(res.Body)
+	for {
+		var msg agentMessage
+		if err := dec.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.Err != "" {
+			return errors.New(msg.Err)
+		}
+		if msg.Result == nil {
+			continue
+		}
+		select {
+		case results <- msg.Result.result(plan.Start):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// newAgentPlan returns the part i of n of the task. The concurrency, the number of
+// transactions and the rates are divided between the agents, the data rows too
+// if they are partitioned or used once, the other settings are the same.
+func newAgentPlan(task *lbstress.Task, scenarios []*lbstress.Scenario, mix bool, i, n int, start time.Time) (*agentPlan, error) {
+	staged := len(task.Stages) > 0
+	rate := task.Rate > 0
+	for _, stage := range task.Stages {
+		rate = rate || stage.Rate > 0
+	}
+	if (!staged || rate) && task.Concurrent < n {
+		return nil, errors.New("Concurrent cannot be smaller than the number of agents")
+	}
+	if task.Rate > 0 && task.Rate < n {
+		return nil, errors.New("Rate cannot be smaller than the number of agents")
+	}
+	plan := &agentPlan{
+		Start:      start,
+		Number:     task.Number,
+		Concurrent: split(task.Concurrent, i, n),
+		Duration:   task.Duration,
+		Rate:       split(task.Rate, i, n),
+		Cookies:    task.Cookies,
+		Mix:        mix,
+	}
+	if task.Number > 0 {
+		if task.Rate > 0 {
+			plan.Number = split(task.Number, i, n)
+		} else if task.Number%task.Concurrent != 0 {
+			return nil, errors.New("Number must be an integer multiple of Concurrent")
+		} else {
+			plan.Number = task.Number / task.Concurrent * plan.Concurrent
+		}
+	}
+	for _, stage := range task.Stages {
+		plan.Stages = append(plan.Stages, lbstress.Stage{
+			Duration:   stage.Duration,
+			Concurrent: split(stage.Concurrent, i, n),
+			Rate:       split(stage.Rate, i, n),
+		})
+	}
+	if task.Feeder != nil {
+		plan.DataMode = task.Feeder.Mode
+		rows := task.Feeder.Rows
+		if plan.DataMode == lbstress.FeedPartitioned || plan.DataMode == lbstress.FeedOnce {
+			rows = rows[i*len(rows)/n : (i+1)*len(rows)/n]
+		}
+		plan.Rows = rows
+	}
+	for _, s := range scenarios {
+		as := &agentScenario{Name: s.Name, Weight: s.Weight}
+		for _, config := range s.Configs {
+			if config.Skip != nil || config.Until != nil || config.Next != nil {
+				return nil, errors.New("the conditions of the steps cannot be run by agents")
+			}
+			as.Requests = append(as.Requests, newAgentRequest(task, config))
+		}
+		plan.Scenarios = append(plan.Scenarios, as)
+	}
+	return plan, nil
+}
+
+// split returns the part i of n of v, the remainder goes to the first parts.
+func split(v, i, n int) int {
+	part := v / n
+	if i < v%n {
+		part++
+	}
+	return part
+}
+
+// newAgentRequest returns the request of config, with the request options of the task if it has none.
+func newAgentRequest(task *lbstress.Task, config *lbstress.RequestConfig) *agentRequest {
+	r := &agentRequest{
+		Name:               config.Name,
+		URL:                config.URLStr,
+		Method:             config.Method,
+		Header:             config.Header,
+		Body:               config.ReqBody,
+		Extractors:         config.Extractors,
+		Checks:             config.Checks,
+		Timeout:            config.Timeout,
+		ThinkTime:          config.ThinkTime,
+		Host:               config.Host,
+		H2:                 config.H2 || task.H2,
+		DisableCompression: config.DisableCompression || task.DisableCompression,
+		DisableKeepAlives:  config.DisableKeepAlives || task.DisableKeepAlives,
+		DisableRedirects:   config.DisableRedirects || task.DisableRedirects,
+		AbortOnFailure:     config.AbortOnFailure || task.AbortOnFailure,
+		Repeat:             config.Repeat,
+	}
+	if r.Timeout <= 0 {
+		r.Timeout = task.Timeout
+	}
+	if r.ThinkTime <= 0 {
+		r.ThinkTime = task.ThinkTime
+	}
+	if r.Host == "" {
+		r.Host = task.Host
+	}
+	proxyURL := config.ProxyAddr
+	if proxyURL == nil {
+		proxyURL = task.ProxyAddr
+	}
+	if proxyURL != nil {
+		r.ProxyAddr = proxyURL.String()
+	}
+	return r
+}
+
+// serveAgent serves the plans of the controllers on addr.
+func serveAgent(addr string) error {
+	return http.ListenAndServe(addr, newAgentHandler())
+}
+
+func newAgentHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/run", handleAgentRun)
+	return mux
+}
+
+// handleAgentRun runs a plan at its start, and streams the result of each
+// transaction to the controller, one JSON message per line. The run is
+// canceled if the controller goes away.
+func handleAgentRun(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.Error(w, "the plan must be posted", http.StatusMethodNotAllowed)
+		return
+	}
+	dec := json.NewDecoder(req.Body)
+	dec.UseNumber()
+	var plan agentPlan
+	if err := dec.Decode(&plan); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	task, scenarios, err := plan.task()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx := req.Context()
+	// Wait for the synchronized start of the agents.
+	if wait := plan.Start.Sub(time.Now()); wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+	results := make(chan *lbstress.Result, 1024)
+	// Only the controller reports, the agent streams its results to it.
+	task.Quiet = true
+	task.OnResult = func(result *lbstress.Result) {
+		select {
+		case results <- result:
+		case <-ctx.Done():
+		}
+	}
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		if plan.Mix {
+			_, err = task.RunMixContext(ctx, scenarios...)
+		} else {
+			_, err = task.RunTranContext(ctx, scenarios[0].Configs...)
+		}
+		close(results)
+		done <- err
+	}()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	for result := range results {
+		enc.Encode(&agentMessage{Result: newAgentResult(result, plan.Start)})
+		if flusher != nil && len(results) == 0 {
+			flusher.Flush()
+		}
+	}
+	if err := <-done; err != nil && err != context.Canceled {
+		enc.Encode(&agentMessage{Err: err.Error()})
+	}
+}
+
+// task returns the task and the transactions of the plan.
+func (p *agentPlan) task() (*lbstress.Task, []*lbstress.Scenario, error) {
+	if len(p.Scenarios) == 0 {
+		return nil, nil, errors.New("the plan has no scenarios")
+	}
+	task := &lbstress.Task{
+		Number:     p.Number,
+		Concurrent: p.Concurrent,
+		Duration:   p.Duration,
+		Rate:       p.Rate,
+		Stages:     p.Stages,
+		Cookies:    p.Cookies,
+	}
+	if p.Rows != nil {
+		task.Feeder = &lbstress.Feeder{Rows: p.Rows, Mode: p.DataMode}
+	}
+	var scenarios []*lbstress.Scenario
+	for _, as := range p.Scenarios {
+		s := &lbstress.Scenario{Name: as.Name, Weight: as.Weight}
+		for _, r := range as.Requests {
+			config := &lbstress.RequestConfig{
+				Name:               r.Name,
+				URLStr:             r.URL,
+				Method:             r.Method,
+				Header:             r.Header,
+				ReqBody:            r.Body,
+				Extractors:         r.Extractors,
+				Checks:             r.Checks,
+				Timeout:            r.Timeout,
+				ThinkTime:          r.ThinkTime,
+				Host:               r.Host,
+				H2:                 r.H2,
+				DisableCompression: r.DisableCompression,
+				DisableKeepAlives:  r.DisableKeepAlives,
+				DisableRedirects:   r.DisableRedirects,
+				AbortOnFailure:     r.AbortOnFailure,
+				Repeat:             r.Repeat,
+			}
+			if r.ProxyAddr != "" {
+				proxyURL, err := gurl.Parse(r.ProxyAddr)
+				if err != nil {
+					return nil, nil, err
+				}
+				config.ProxyAddr = proxyURL
+			}
+			s.Configs = append(s.Configs, config)
+		}
+		scenarios = append(scenarios, s)
+	}
+	return task, scenarios, nil
+}
+
+func newAgentResult(result *lbstress.Result, start time.Time) *agentResult {
+	r := &agentResult{
+		Start:             result.Start.Sub(start),
+		Duration:          result.Duration,
+		CorrectedDuration: result.CorrectedDuration,
+		Stage:             result.Stage,
+		Scenario:          result.Scenario,
+		Aborted:           result.Aborted,
+		AbortReason:       result.AbortReason,
+	}
+	for _, res := range result.Details {
+		d := &agentDetail{
+			Step:              res.Step,
+			URL:               res.URLStr,
+			Method:            res.Method,
+			Start:             res.Start.Sub(start),
+			StatusCode:        res.StatusCode,
+			Duration:          res.Duration,
+			ConnDuration:      res.ConnDuration,
+			DNSDuration:       res.DNSDuration,
+			ReqDuration:       res.ReqDuration,
+			ResDuration:       res.ResDuration,
+			DelayDuration:     res.DelayDuration,
+			ReqBeforeDuration: res.ReqBeforeDuration,
+			ResAfterDuration:  res.ResAfterDuration,
+			ContentLength:     res.ContentLength,
+			Checks:            res.Checks,
+			Skipped:           res.Skipped,
+		}
+		if res.Err != nil {
+			d.Err = res.Err.Error()
+		}
+		r.Details = append(r.Details, d)
+	}
+	return r
+}
+
+// result returns the result of the transaction, with start times in the local time of start.
+func (r *agentResult) result(start time.Time) *lbstress.Result {
+	result := &lbstress.Result{
+		Start:             start.Add(r.Start),
+		Duration:          r.Duration,
+		CorrectedDuration: r.CorrectedDuration,
+		Stage:             r.Stage,
+		Scenario:          r.Scenario,
+		Aborted:           r.Aborted,
+		AbortReason:       r.AbortReason,
+	}
+	for _, d := range r.Details {
+		if d == nil {
+			d = &agentDetail{Err: "missing request detail"}
+		}
+		res := &lbstress.ResultDetail{
+			Step:              d.Step,
+			URLStr:            d.URL,
+			Method:            d.Method,
+			Start:             start.Add(d.Start),
+			StatusCode:        d.StatusCode,
+			Duration:          d.Duration,
+			ConnDuration:      d.ConnDuration,
+			DNSDuration:       d.DNSDuration,
+			ReqDuration:       d.ReqDuration,
+			ResDuration:       d.ResDuration,
+			DelayDuration:     d.DelayDuration,
+			ReqBeforeDuration: d.ReqBeforeDuration,
+			ResAfterDuration:  d.ResAfterDuration,
+			ContentLength:     d.ContentLength,
+			Checks:            d.Checks,
+			Skipped:           d.Skipped,
+		}
+		if d.Err != "" {
+			res.Err = errors.New(d.Err)
+		}
+		result.Details = append(result.Details, res)
+	}
+	return result
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	gurl "net/url"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	lbstress "github.com/wenjiax/stress/stress"
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	// scenario is a task and its ordered requests described in a JSON or YAML file,
+	// or a task and a weighted mix of named transactions of ordered requests.
+	// The settings that are not in the file keep the value of the options.
+	scenario struct {
+		Number      *int              `json:"number"`
+		Concurrent  int               `json:"concurrent"`
+		Duration    scenarioDuration  `json:"duration"`
+		Rate        int               `json:"rate"`
+		Stages      []scenarioStage   `json:"stages"`
+		Percentiles []float64         `json:"percentiles"`
+		Output      string            `json:"output"`
+		Interval    scenarioDuration  `json:"interval"`
+		Format      string            `json:"format"`
+		MetricsAddr string            `json:"metricsAddr"`
+		Data        string            `json:"data"`
+		DataMode    string            `json:"dataMode"`
+		Cookies     string            `json:"cookies"`
+		Thresholds  []string          `json:"thresholds"`
+		Abort       *scenarioAbort    `json:"abort"`
+		Header      map[string]string `json:"header"`
+		Steps       []*scenarioStep   `json:"steps"`
+		Scenarios   []*scenarioMix    `json:"scenarios"`
+		scenarioOptions
+	}
+	// scenarioMix is a named transaction of the mix and its share of the transactions.
+	scenarioMix struct {
+		Name   string          `json:"name"`
+		Weight int             `json:"weight"`
+		Steps  []*scenarioStep `json:"steps"`
+	}
+	// scenarioAbort is the limits to abort the task.
+	scenarioAbort struct {
+		Window              scenarioDuration `json:"window"`
+		MinTransactions     int              `json:"minTransactions"`
+		ErrorRate           float64          `json:"errorRate"`
+		P99                 scenarioDuration `json:"p99"`
+		ConsecutiveFailures int              `json:"consecutiveFailures"`
+	}
+	// scenarioStage is a stage of the load profile.
+	scenarioStage struct {
+		Duration   scenarioDuration `json:"duration"`
+		Concurrent int              `json:"concurrent"`
+		Rate       int              `json:"rate"`
+	}
+	// scenarioStep is a request of the transaction.
+	scenarioStep struct {
+		Name       string             `json:"name"`
+		URL        string             `json:"url"`
+		Method     string             `json:"method"`
+		Header     map[string]string  `json:"header"`
+		Body       string             `json:"body"`
+		BodyFile   string             `json:"bodyFile"`
+		Extract    []*scenarioExtract `json:"extract"`
+		Checks     []*scenarioCheck   `json:"checks"`
+		Thresholds []string           `json:"thresholds"`
+		Repeat     int                `json:"repeat"`
+		SkipIf     string             `json:"skipIf"`
+		Until      string             `json:"until"`
+		Next       string             `json:"next"`
+		scenarioOptions
+	}
+	// scenarioExtract stores a value of the response for the next steps.
+	scenarioExtract struct {
+		Name    string `json:"name"`
+		From    string `json:"from"`
+		Expr    string `json:"expr"`
+		Default string `json:"default"`
+	}
+	// scenarioCheck is an assertion on the response.
+	scenarioCheck struct {
+		Name  string `json:"name"`
+		Type  string `json:"type"`
+		Expr  string `json:"expr"`
+		Value string `json:"value"`
+	}
+	// scenarioOptions is the request options of the task and of each step.
+	scenarioOptions struct {
+		Timeout            *int   `json:"timeout"`
+		ThinkTime          int    `json:"thinkTime"`
+		ProxyAddr          string `json:"proxyAddr"`
+		Host               string `json:"host"`
+		H2                 bool   `json:"h2"`
+		DisableCompression bool   `json:"disableCompression"`
+		DisableKeepAlives  bool   `json:"disableKeepAlives"`
+		DisableRedirects   bool   `json:"disableRedirects"`
+		AbortOnFailure     bool   `json:"abortOnFailure"`
+	}
+	// scenarioDuration is a duration such as "1m30s", or a number of seconds.
+	scenarioDuration time.Duration
+)
+
+func (d *scenarioDuration) UnmarshalJSON(b []byte) error {
+	var secs float64
+	if err := json.Unmarshal(b, &secs); err == nil {
+		*d = scenarioDuration(secs * float64(time.Second))
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("could not parse the provided duration; input = %s", b)
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = scenarioDuration(duration)
+	return nil
+}
+
+// loadScenario reads a scenario file, the body files of the steps are relative to it.
+// A .yaml or .yml file is YAML with the same fields, other files are JSON.
+func loadScenario(path string) (*scenario, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yaml" || ext == ".yml" {
+		if content, err = yamlToJSON(content); err != nil {
+			return nil, fmt.Errorf("could not parse the scenario %v: %v", path, err)
+		}
+	}
+	s := &scenario{}
+	if err := json.Unmarshal(content, s); err != nil {
+		return nil, fmt.Errorf("could not parse the scenario %v: %v", path, err)
+	}
+	if len(s.Steps) == 0 && len(s.Scenarios) == 0 {
+		return nil, errors.New("the scenario has no steps")
+	}
+	if len(s.Steps) > 0 && len(s.Scenarios) > 0 {
+		return nil, errors.New("the scenario has both steps and scenarios")
+	}
+	if s.Data != "" && !filepath.IsAbs(s.Data) {
+		s.Data = filepath.Join(filepath.Dir(path), s.Data)
+	}
+	steps := s.Steps
+	for _, m := range s.Scenarios {
+		if len(m.Steps) == 0 {
+			return nil, fmt.Errorf("the scenario %v has no steps", m.Name)
+		}
+		steps = append(steps, m.Steps...)
+	}
+	for _, step := range steps {
+		if step.BodyFile != "" && !filepath.IsAbs(step.BodyFile) {
+			step.BodyFile = filepath.Join(filepath.Dir(path), step.BodyFile)
+		}
+	}
+	return s, nil
+}
+
+// yamlToJSON converts a YAML scenario to JSON, so that it is read as a JSON scenario.
+func yamlToJSON(content []byte) ([]byte, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(content, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// apply sets the settings of the scenario on task, and returns its transactions,
+// a single unnamed one if the scenario is not a mix.
+// Header is the header of the options, overridden by the scenario header.
+func (s *scenario) apply(task *lbstress.Task, header http.Header) ([]*lbstress.Scenario, error) {
+	if s.Number != nil {
+		task.Number = *s.Number
+	} else if s.Duration > 0 || s.Stages != nil {
+		task.Number = 0
+	}
+	if s.Concurrent > 0 {
+		task.Concurrent = s.Concurrent
+	}
+	if s.Duration > 0 {
+		task.Duration = time.Duration(s.Duration)
+	}
+	if s.Rate > 0 {
+		task.Rate = s.Rate
+	}
+	if s.Stages != nil {
+		task.Stages = nil
+		for _, stage := range s.Stages {
+			task.Stages = append(task.Stages, lbstress.Stage{
+				Duration:   time.Duration(stage.Duration),
+				Concurrent: stage.Concurrent,
+				Rate:       stage.Rate,
+			})
+		}
+	}
+	if s.Percentiles != nil {
+		task.Percentiles = s.Percentiles
+	}
+	if s.Output != "" {
+		task.Output = s.Output
+	}
+	if s.Interval > 0 {
+		task.Interval = time.Duration(s.Interval)
+	}
+	if s.Format != "" {
+		task.Format = s.Format
+	}
+	if s.MetricsAddr != "" {
+		task.MetricsAddr = s.MetricsAddr
+	}
+	if s.Data != "" {
+		feeder, err := lbstress.LoadFeeder(s.Data)
+		if err != nil {
+			return nil, err
+		}
+		task.Feeder = feeder
+	}
+	if s.Thresholds != nil {
+		task.Thresholds = append(task.Thresholds, s.Thresholds...)
+	}
+	if s.Abort != nil {
+		task.Abort = &lbstress.Abort{
+			Window:              time.Duration(s.Abort.Window),
+			MinTransactions:     s.Abort.MinTransactions,
+			ErrorRate:           s.Abort.ErrorRate,
+			P99:                 time.Duration(s.Abort.P99),
+			ConsecutiveFailures: s.Abort.ConsecutiveFailures,
+		}
+	}
+	if s.DataMode != "" && task.Feeder != nil {
+		task.Feeder.Mode = s.DataMode
+	}
+	if s.Cookies != "" {
+		task.Cookies = s.Cookies
+	}
+	if s.Timeout != nil {
+		task.Timeout = *s.Timeout
+	}
+	if s.ThinkTime > 0 {
+		task.ThinkTime = s.ThinkTime
+	}
+	if s.ProxyAddr != "" {
+		proxyURL, err := gurl.Parse(s.ProxyAddr)
+		if err != nil {
+			return nil, err
+		}
+		task.ProxyAddr = proxyURL
+	}
+	if s.Host != "" {
+		task.Host = s.Host
+	}
+	task.H2 = task.H2 || s.H2
+	task.DisableCompression = task.DisableCompression || s.DisableCompression
+	task.DisableKeepAlives = task.DisableKeepAlives || s.DisableKeepAlives
+	task.DisableRedirects = task.DisableRedirects || s.DisableRedirects
+	task.AbortOnFailure = task.AbortOnFailure || s.AbortOnFailure
+
+	taskHeader := cloneHeader(header)
+	for k, v := range s.Header {
+		taskHeader.Set(k, v)
+	}
+	mix := s.Scenarios
+	if len(mix) == 0 {
+		mix = []*scenarioMix{{Steps: s.Steps}}
+	}
+	var scenarios []*lbstress.Scenario
+	for _, m := range mix {
+		scenario := &lbstress.Scenario{Name: m.Name, Weight: m.Weight}
+		for _, step := range m.Steps {
+			config, err := step.requestConfig(taskHeader)
+			if err != nil {
+				return nil, err
+			}
+			scenario.Configs = append(scenario.Configs, config)
+		}
+		scenarios = append(scenarios, scenario)
+	}
+	return scenarios, nil
+}
+
+func (step *scenarioStep) requestConfig(header http.Header) (*lbstress.RequestConfig, error) {
+	config := &lbstress.RequestConfig{
+		Name:               step.Name,
+		URLStr:             step.URL,
+		Method:             step.Method,
+		Header:             cloneHeader(header),
+		ThinkTime:          step.ThinkTime,
+		Thresholds:         step.Thresholds,
+		Host:               step.Host,
+		H2:                 step.H2,
+		DisableCompression: step.DisableCompression,
+		DisableKeepAlives:  step.DisableKeepAlives,
+		DisableRedirects:   step.DisableRedirects,
+		AbortOnFailure:     step.AbortOnFailure,
+		Repeat:             step.Repeat,
+	}
+	if config.Method == "" {
+		config.Method = "GET"
+	}
+	for k, v := range step.Header {
+		config.Header.Set(k, v)
+	}
+	for _, e := range step.Extract {
+		config.Extractors = append(config.Extractors, &lbstress.Extractor{
+			Name:    e.Name,
+			Source:  e.From,
+			Expr:    e.Expr,
+			Default: e.Default,
+		})
+	}
+	for _, c := range step.Checks {
+		config.Checks = append(config.Checks, &lbstress.Check{
+			Name:  c.Name,
+			Type:  c.Type,
+			Expr:  c.Expr,
+			Value: c.Value,
+		})
+	}
+	if step.Body != "" {
+		config.ReqBody = []byte(step.Body)
+	}
+	if step.BodyFile != "" {
+		content, err := ioutil.ReadFile(step.BodyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ReqBody = content
+	}
+	if step.Timeout != nil {
+		config.Timeout = *step.Timeout
+	}
+	if step.ProxyAddr != "" {
+		proxyURL, err := gurl.Parse(step.ProxyAddr)
+		if err != nil {
+			return nil, err
+		}
+		config.ProxyAddr = proxyURL
+	}
+	// The conditions and the jump of the step are templates on the share of the transaction.
+	if step.SkipIf != "" {
+		tmpl, err := parseStepTemplate("skipIf", step.SkipIf)
+		if err != nil {
+			return nil, err
+		}
+		config.Skip = func(share lbstress.Share) bool {
+			return renderStepTemplate(tmpl, share) == "true"
+		}
+	}
+	if step.Until != "" {
+		tmpl, err := parseStepTemplate("until", step.Until)
+		if err != nil {
+			return nil, err
+		}
+		config.Until = func(share lbstress.Share) bool {
+			return renderStepTemplate(tmpl, share) == "true"
+		}
+	}
+	if step.Next != "" {
+		tmpl, err := parseStepTemplate("next", step.Next)
+		if err != nil {
+			return nil, err
+		}
+		config.Next = func(share lbstress.Share) string {
+			return renderStepTemplate(tmpl, share)
+		}
+	}
+	return config, nil
+}
+
+func parseStepTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse the provided %v; input = %v", name, text)
+	}
+	return tmpl, nil
+}
+
+// renderStepTemplate renders tmpl with the share, such as {{.Share.token}}, an error renders nothing.
+func renderStepTemplate(tmpl *template.Template, share lbstress.Share) string {
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, struct{ Share lbstress.Share }{share}); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func cloneHeader(h http.Header) http.Header {
+	c := make(http.Header, len(h))
+	for k, v := range h {
+		c[k] = append([]string(nil), v...)
+	}
+	return c
+}
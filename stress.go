@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	gurl "net/url"
 	"os"
+	"os/signal"
 	"regexp"
 	"strconv"
 	"strings"
@@ -18,23 +20,39 @@ import (
 var (
 	m = flag.String("m", "GET", "")
 	// headers  = flag.String("h", "", "")
-	body     = flag.String("b", "", "")
-	bodyFile = flag.String("B", "", "")
+	body         = flag.String("b", "", "")
+	bodyFile     = flag.String("B", "", "")
+	scenarioFile = flag.String("f", "", "")
 
-	output    = flag.String("o", "", "")
-	proxyAddr = flag.String("x", "", "")
-	host      = flag.String("host", "", "")
+	stages      = flag.String("stages", "", "")
+	pctls       = flag.String("percentiles", "", "")
+	output      = flag.String("o", "", "")
+	format      = flag.String("format", "text", "")
+	proxyAddr   = flag.String("x", "", "")
+	host        = flag.String("host", "", "")
+	metricsAddr = flag.String("metrics-addr", "", "")
+	data        = flag.String("data", "", "")
+	dataMode    = flag.String("data-mode", "sequential", "")
+	cookies     = flag.String("cookies", "", "")
 
 	n         = flag.Int("n", 100, "")
 	c         = flag.Int("c", 10, "")
 	t         = flag.Int("t", 20, "")
 	d         = flag.Int("d", 0, "")
+	rate      = flag.Int("rate", 0, "")
 	thinkTime = flag.Int("think-time", 0, "")
 
+	interval       = flag.Duration("interval", time.Second, "")
+	abortErrorRate = flag.Float64("abort-error-rate", 0, "")
+	abortP99       = flag.Duration("abort-p99", 0, "")
+	abortFailures  = flag.Int("abort-failures", 0, "")
+	abortWindow    = flag.Duration("abort-window", 10*time.Second, "")
+
 	h2                 = flag.Bool("h2", false, "")
 	disableCompression = flag.Bool("disable-compression", false, "")
 	disableKeepalive   = flag.Bool("disable-keepalive", false, "")
 	disableRedirects   = flag.Bool("disable-redirects", false, "")
+	abortOnFailure     = flag.Bool("abort-on-failure", false, "")
 	enableTran         = flag.Bool("enable-tran", false, "")
 )
 
@@ -46,19 +64,76 @@ const (
 	bodyFileRegexp  = `B:([^,]+),*`
 	proxyAddrRegexp = `x:([^,]+),*`
 	thinkTimeRegexp = `thinkTime:([\d]+),*`
+
+	stageRegexp = `^([^:]+):(\d+)(/s)?$`
+
+	// thresholdsExitCode is the exit code when a threshold failed.
+	thresholdsExitCode = 2
+	// abortedExitCode is the exit code when the task was aborted.
+	abortedExitCode = 3
 )
 
 var usage = `Usage: stress [options...] <url> || stress [options...] -enable-tran <urls...>
+       || stress [options...] run <scenario.json>
+       || stress agent <address>
+       || stress [options...] controller <agents> <url>|run <scenario.json>
 
 Options:
-  -n  Number of requests to run. Default value is 100.
+  -n  Number of requests to run. Default value is 100, unless -d or
+      -stages is set, it cannot be set with them.
       If set to -1, the request has been sent, but the report will 
       not be output by default.
   -c  Number of requests to run concurrently. 
       Total number of requests cannot smaller than the concurrency level. 
       Default value is 10.
   -d  Duration of requests to run. Default value is 0 sec.
+  -rate  Number of transactions to start per second. Transactions are
+         started on a fixed timeline regardless of response times, -c
+         caps the number of in-flight transactions, the starts that are
+         late at the end of -d are reported as missed. Default value is
+         0, which sends requests back-to-back.
+  -stages  Multi-stage load profile, a comma-separated list of
+           duration:target. Each stage ramps linearly from the previous
+           target (starting at 0) to its own. Targets are concurrency
+           levels, or rates when suffixed with "/s". Cannot be used
+           with -n or -d. For example: -stages 30s:10,2m:200,30s:0
+           or -stages 1m:500/s,5m:500/s.
   -o  Output file path. For example: /home/user or ./files.
+      Contains the report, a CSV file for each URL, the time series
+      and index.html, a self-contained HTML report with charts.
+  -interval  Interval of the time series written to the output, as
+             timeseries.csv or timeseries.json with -format json.
+             Transactions and requests are counted in the interval
+             they started. Default value is 1s.
+  -format  Report format, either text or json. Default value is text.
+  -percentiles  Comma-separated latency percentiles to report.
+                Default value is 50,90,95,99,99.9.
+  -threshold  Pass or fail criterion, exits with code 2 if it fails.
+              Repeatable. A metric, an operator and a value, such as
+              -threshold 'p99<300ms' -threshold 'errors<1%'
+              -threshold 'rps>800'. Metrics are p<percentile>, avg, min,
+              max, errors (a count, or a rate with %), rps and count.
+              A latency threshold without any response fails.
+  -abort-error-rate  Abort when the fraction of failed transactions over
+                     the abort window exceeds it, such as 0.5.
+  -abort-p99  Abort when the 99th percentile latency over the abort
+              window exceeds it, such as 2s.
+  -abort-failures  Abort after this many failed transactions in a row.
+  -abort-window  Rolling window of the abort limits, at least 100ms.
+                 Default value is 10s. An aborted run reports the transactions until
+                 then and exits with code 3.
+  -data  Data file of the transactions, a CSV file whose first line
+         is the column names or a JSONL file of one object per line.
+         Each transaction gets a row, whose columns are available to
+         the templates, such as {{.Share.user_id}}.
+  -data-mode  Consumption of the data rows: sequential, random,
+              partitioned (each worker gets its own rows) or once
+              (stops when the rows are exhausted). Default value
+              is sequential.
+  -cookies  Keep the cookies of the responses for the next requests of
+            each worker (virtual user): transaction (kept for the steps
+            of a transaction) or user (kept across its transactions).
+            Default value is empty, the cookies are not kept.
   
   -h  Custom HTTP header. For example: 
       -h "Accept: text/html" -h "Content-Type: application/xml".
@@ -78,12 +153,31 @@ Options:
   -disable-keepalive    Disable keep-alive, prevents re-use of TCP
                     	connections between different HTTP requests.
   -disable-redirects    Disable following of HTTP redirects.
+  -abort-on-failure     Abort a transaction at its first failed request, the
+                        next requests are not sent. The aborted transactions
+                        are reported apart.
+  -metrics-addr         Serve Prometheus metrics on /metrics at the address
+                        while running. For example: -metrics-addr :9102.
   -enable-tran          Enable transactional requests. Multiple urls 
                         form a transactional requests. 
                         For example: "stress [options...] -enable-tran 
                         http://localhost:8080,m:post,b:hi,x:http://127.0.0.1:8888 
                         http://localhost:8888,m:post,B:/home/file.txt,thinkTime:2 
                         [urls...]".
+  -f                    Scenario file, the same as "stress [options...] run
+                        <scenario.json>". A JSON or YAML (.yaml or .yml)
+                        file describing the task and its ordered steps, or a
+                        weighted mix of named transactions of steps, the
+                        settings that are not in the file keep the value of
+                        the options.
+
+  agent <address>       Run as an agent listening on the address, such as
+                        :7070, running the parts of the tasks of controllers.
+  controller <agents>   Run the task on the comma-separated agents, such as
+                        host1:7070,host2:7070. Concurrency, number of
+                        requests, rates and stages are divided between the
+                        agents, which start in sync. Their results are
+                        merged into one report.
 `
 
 func main() {
@@ -92,8 +186,29 @@ func main() {
 	}
 	var hs headerSlice
 	flag.Var(&hs, "h", "")
+	var ths headerSlice
+	flag.Var(&ths, "threshold", "")
 	flag.Parse()
-	if flag.NArg() <= 0 {
+	args := flag.Args()
+	// "stress agent <address>" runs the parts of the tasks of a controller.
+	if len(args) == 2 && args[0] == "agent" {
+		if err := serveAgent(args[1]); err != nil {
+			errAndExit(err.Error())
+		}
+		return
+	}
+	// "stress controller <agents> ..." runs the task on the agents.
+	var agents []string
+	if len(args) >= 2 && args[0] == "controller" {
+		agents = strings.Split(args[1], ",")
+		args = args[2:]
+	}
+	// The scenario file is given by -f or by "stress run <file>".
+	scenarioPath := *scenarioFile
+	if scenarioPath == "" && len(args) == 2 && args[0] == "run" {
+		scenarioPath = args[1]
+	}
+	if len(args) <= 0 && scenarioPath == "" {
 		usageAndExit("")
 	}
 	// Parsing global request header.
@@ -115,30 +230,115 @@ func main() {
 			usageAndExit(err.Error())
 		}
 	}
+	// Parsing load profile stages.
+	var stageList []lbstress.Stage
+	if *stages != "" {
+		var err error
+		stageList, err = parseStages(*stages)
+		if err != nil {
+			usageAndExit(err.Error())
+		}
+	}
+	// Parsing latency percentiles.
+	var percentiles []float64
+	if *pctls != "" {
+		var err error
+		percentiles, err = parsePercentiles(*pctls)
+		if err != nil {
+			usageAndExit(err.Error())
+		}
+	}
+	// Loading the data feeder.
+	var feeder *lbstress.Feeder
+	if *data != "" {
+		var err error
+		feeder, err = lbstress.LoadFeeder(*data)
+		if err != nil {
+			usageAndExit(err.Error())
+		}
+		feeder.Mode = *dataMode
+	}
+	// Setting the abort limits.
+	var abort *lbstress.Abort
+	if *abortErrorRate > 0 || *abortP99 > 0 || *abortFailures > 0 {
+		abort = &lbstress.Abort{
+			Window:              *abortWindow,
+			ErrorRate:           *abortErrorRate,
+			P99:                 *abortP99,
+			ConsecutiveFailures: *abortFailures,
+		}
+	}
+	// The default number of requests does not apply to duration or stages,
+	// an explicit -n with them is rejected by the task.
+	number := *n
+	if (*d > 0 || stageList != nil) && !isFlagSet("n") {
+		number = 0
+	}
 	// Set parameters and global configuration.
 	task := &lbstress.Task{
-		Number:             *n,
+		Number:             number,
 		Concurrent:         *c,
 		Duration:           time.Duration(*d) * time.Second,
+		Rate:               *rate,
+		Stages:             stageList,
+		Percentiles:        percentiles,
 		Output:             *output,
+		Interval:           *interval,
+		Format:             *format,
+		MetricsAddr:        *metricsAddr,
+		Feeder:             feeder,
+		Cookies:            *cookies,
+		Thresholds:         ths,
+		Abort:              abort,
 		Timeout:            *t,
 		ThinkTime:          *thinkTime,
 		ProxyAddr:          proxyURL,
 		DisableCompression: *disableCompression,
 		DisableKeepAlives:  *disableKeepalive,
 		DisableRedirects:   *disableRedirects,
+		AbortOnFailure:     *abortOnFailure,
 		Host:               *host,
 		H2:                 *h2,
 	}
-	if *enableTran {
-		runTran(task, header)
-	} else {
-		run(task, header)
+	// Show the live progress when running in a terminal.
+	if fi, err := os.Stderr.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		task.OnProgress = printProgress
+		defer fmt.Fprintf(os.Stderr, "\n")
+	}
+	// Stop the task on interrupt, the report of the transactions until then is printed.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	go func() {
+		<-c
+		cancel()
+	}()
+	var report *lbstress.Report
+	switch {
+	case scenarioPath != "":
+		report = runScenario(ctx, task, header, scenarioPath, agents)
+	case *enableTran:
+		report = runTran(ctx, task, header, args, agents)
+	default:
+		report = run(ctx, task, header, args, agents)
+	}
+	if ctx.Err() != nil {
+		fmt.Fprintf(os.Stderr, "\nError:interrupted\n")
+		os.Exit(1)
+	}
+	if report.Aborted != "" {
+		fmt.Fprintf(os.Stderr, "\nError:aborted, %s\n", report.Aborted)
+		os.Exit(abortedExitCode)
+	}
+	if !report.Passed() {
+		fmt.Fprintf(os.Stderr, "\nError:thresholds failed\n")
+		os.Exit(thresholdsExitCode)
 	}
 
 }
 
-func run(task *lbstress.Task, header http.Header) {
+func run(ctx context.Context, task *lbstress.Task, header http.Header, args, agents []string) *lbstress.Report {
 	// Parsing request body.
 	var bodyAll []byte
 	if *body != "" {
@@ -152,21 +352,18 @@ func run(task *lbstress.Task, header http.Header) {
 		bodyAll = content
 	}
 	// Run task.
-	err := task.Run(&lbstress.RequestConfig{
-		URLStr:  flag.Args()[0],
+	config := &lbstress.RequestConfig{
+		URLStr:  args[0],
 		Method:  *m,
 		ReqBody: bodyAll,
 		Header:  header,
-	})
-	if err != nil {
-		errAndExit(err.Error())
 	}
+	return execute(ctx, task, []*lbstress.Scenario{{Configs: []*lbstress.RequestConfig{config}}}, false, agents)
 }
 
-func runTran(task *lbstress.Task, header http.Header) {
+func runTran(ctx context.Context, task *lbstress.Task, header http.Header, args, agents []string) *lbstress.Report {
 	var configs []*lbstress.RequestConfig
-	for i, len := 0, flag.NArg(); i < len; i++ {
-		argstr := flag.Args()[i]
+	for _, argstr := range args {
 		url := strings.Split(argstr, ",")[0]
 		// Parsing request method.
 		methodMatch, err := parseInputWithRegexp(argstr, methodsRegexp)
@@ -213,10 +410,45 @@ func runTran(task *lbstress.Task, header http.Header) {
 		})
 	}
 	// Run transactional task.
-	err := task.RunTran(configs...)
+	return execute(ctx, task, []*lbstress.Scenario{{Configs: configs}}, false, agents)
+}
+
+func runScenario(ctx context.Context, task *lbstress.Task, header http.Header, path string, agents []string) *lbstress.Report {
+	s, err := loadScenario(path)
 	if err != nil {
 		errAndExit(err.Error())
 	}
+	scenarios, err := s.apply(task, header)
+	if err != nil {
+		errAndExit(err.Error())
+	}
+	// Run the transactional task of the scenario, or its mix of transactions.
+	return execute(ctx, task, scenarios, len(s.Scenarios) > 0, agents)
+}
+
+// execute runs the transaction of the first scenario, or the mix of the scenarios,
+// on the task or on the agents if any, and returns the report.
+func execute(ctx context.Context, task *lbstress.Task, scenarios []*lbstress.Scenario, mix bool, agents []string) *lbstress.Report {
+	var result *lbstress.RunResult
+	var err error
+	switch {
+	case agents != nil:
+		result, err = runController(ctx, task, scenarios, mix, agents)
+	case mix:
+		result, err = task.RunMixContext(ctx, scenarios...)
+	default:
+		result, err = task.RunTranContext(ctx, scenarios[0].Configs...)
+	}
+	if err != nil && err != context.Canceled {
+		errAndExit(err.Error())
+	}
+	return result.Report
+}
+
+func printProgress(s lbstress.Snapshot) {
+	fmt.Fprintf(os.Stderr, "\r%v elapsed, %v remaining | %d done, %d in-flight | %.1f req/s | p50 %.4f secs, p99 %.4f secs | %.2f%% errors  ",
+		s.Elapsed/time.Second*time.Second, s.Remaining/time.Second*time.Second, s.Transactions, s.InFlight,
+		s.RPS, s.P50.Seconds(), s.P99.Seconds(), s.ErrorRate*100)
 }
 
 func parseInputWithRegexp(input, regx string) ([]string, error) {
@@ -228,6 +460,41 @@ func parseInputWithRegexp(input, regx string) ([]string, error) {
 	return matches, nil
 }
 
+func parseStages(input string) ([]lbstress.Stage, error) {
+	var stageList []lbstress.Stage
+	for _, s := range strings.Split(input, ",") {
+		match, err := parseInputWithRegexp(strings.TrimSpace(s), stageRegexp)
+		if err != nil {
+			return nil, err
+		}
+		duration, err := time.ParseDuration(match[1])
+		if err != nil {
+			return nil, err
+		}
+		target, _ := strconv.Atoi(match[2])
+		stage := lbstress.Stage{Duration: duration}
+		if match[3] != "" {
+			stage.Rate = target
+		} else {
+			stage.Concurrent = target
+		}
+		stageList = append(stageList, stage)
+	}
+	return stageList, nil
+}
+
+func parsePercentiles(input string) ([]float64, error) {
+	var percentiles []float64
+	for _, s := range strings.Split(input, ",") {
+		p, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse the provided percentile; input = %v", s)
+		}
+		percentiles = append(percentiles, p)
+	}
+	return percentiles, nil
+}
+
 func usageAndExit(msg string) {
 	if msg != "" {
 		fmt.Fprintf(os.Stderr, msg)
@@ -253,3 +520,14 @@ func (h *headerSlice) Set(value string) error {
 	*h = append(*h, value)
 	return nil
 }
+
+// isFlagSet reports whether the flag name is set on the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
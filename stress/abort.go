@@ -0,0 +1,161 @@
+package stress
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultAbortWindow is the rolling window of Abort if Window is not set.
+	defaultAbortWindow = 10 * time.Second
+	// minAbortWindow is the smallest rolling window of Abort.
+	minAbortWindow = 100 * time.Millisecond
+	// defaultAbortMinTransactions is the MinTransactions of Abort if it is not set.
+	defaultAbortMinTransactions = 10
+	// abortSlots is the number of slots of the rolling window, it moves by one slot at a time.
+	abortSlots = 10
+)
+
+type (
+	// Abort stops the task before its end when a limit is exceeded, the transactions
+	// in progress are completed and the report covers the transactions until then.
+	// The limits that are not set are not checked.
+	Abort struct {
+		// Window is the duration of the rolling window of the error rate and the latency.
+		// Default value is 10 seconds, it cannot be smaller than 100 milliseconds.
+		Window time.Duration
+		// MinTransactions is the number of transactions in the window before the
+		// error rate and the latency are checked. Default value is 10.
+		MinTransactions int
+		// ErrorRate is the largest fraction of failed transactions in the window, such as 0.5.
+		ErrorRate float64
+		// P99 is the largest 99th percentile latency of the transactions in the window.
+		P99 time.Duration
+		// ConsecutiveFailures is the largest number of failed transactions in a row.
+		ConsecutiveFailures int
+	}
+	// abortWatcher follows the transactions of a running task for the Abort limits.
+	abortWatcher struct {
+		mx          sync.Mutex
+		abort       *Abort
+		slot        time.Duration
+		slots       [abortSlots]abortSlot
+		consecutive int
+	}
+	// abortSlot is the transactions that ended in a slot of the rolling window.
+	abortSlot struct {
+		index    int
+		duration histogram
+		errCount int64
+	}
+)
+
+// check checks the limits of the abort.
+func (a *Abort) check() error {
+	if a.Window < 0 || a.MinTransactions < 0 || a.ErrorRate < 0 || a.P99 < 0 || a.ConsecutiveFailures < 0 {
+		return errors.New("Abort limits cannot be smaller than 0")
+	}
+	if a.Window > 0 && a.Window < minAbortWindow {
+		return fmt.Errorf("Abort Window cannot be smaller than %v", minAbortWindow)
+	}
+	if a.ErrorRate > 1 {
+		return errors.New("Abort ErrorRate cannot be greater than 1")
+	}
+	return nil
+}
+
+func newAbortWatcher(a *Abort) *abortWatcher {
+	window := a.Window
+	if window == 0 {
+		window = defaultAbortWindow
+	}
+	w := &abortWatcher{abort: a, slot: window / abortSlots}
+	for i := range w.slots {
+		w.slots[i].index = -1
+	}
+	return w
+}
+
+// add records a transaction ended at offset after the start of the task,
+// it returns the abort reason if there are too many consecutive failures.
+func (w *abortWatcher) add(result *Result, failed bool, offset time.Duration) string {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	i := int(offset / w.slot)
+	s := &w.slots[i%abortSlots]
+	if s.index != i {
+		s.index = i
+		s.duration.reset()
+		s.errCount = 0
+	}
+	s.duration.record(result.Duration)
+	if !failed {
+		w.consecutive = 0
+		return ""
+	}
+	s.errCount++
+	w.consecutive++
+	if limit := w.abort.ConsecutiveFailures; limit > 0 && w.consecutive >= limit {
+		return fmt.Sprintf("%d consecutive failed transactions", w.consecutive)
+	}
+	return ""
+}
+
+// reason returns the abort reason for the rolling window ending at offset, if any.
+func (w *abortWatcher) reason(offset time.Duration) string {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	current := int(offset / w.slot)
+	var window histogram
+	var errCount int64
+	for i := range w.slots {
+		if s := &w.slots[i]; s.index >= 0 && s.index > current-abortSlots {
+			window.merge(&s.duration)
+			errCount += s.errCount
+		}
+	}
+	min := int64(w.abort.MinTransactions)
+	if min == 0 {
+		min = defaultAbortMinTransactions
+	}
+	if window.count == 0 || window.count < min {
+		return ""
+	}
+	span := w.slot * abortSlots
+	if rate := float64(errCount) / float64(window.count); w.abort.ErrorRate > 0 && rate > w.abort.ErrorRate {
+		return fmt.Sprintf("error rate %.2f%% above %.2f%% over %v", rate*100, w.abort.ErrorRate*100, span)
+	}
+	if p99 := window.quantile(0.99); w.abort.P99 > 0 && p99 > w.abort.P99 {
+		return fmt.Sprintf("p99 latency %v above %v over %v", p99, w.abort.P99, span)
+	}
+	return ""
+}
+
+// watchAbort checks the rolling window every slot until done is closed.
+func (r *runner) watchAbort(done <-chan struct{}) {
+	ticker := time.NewTicker(r.abortWatcher.slot)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			if reason := r.abortWatcher.reason(now.Sub(r.start)); reason != "" {
+				r.abort(reason)
+				return
+			}
+		}
+	}
+}
+
+// abort stops the task for reason, only the first reason is kept.
+func (r *runner) abort(reason string) {
+	r.mx.Lock()
+	if r.aborted == "" {
+		r.aborted = reason
+	}
+	r.mx.Unlock()
+	r.stop()
+}
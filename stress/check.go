@@ -0,0 +1,191 @@
+package stress
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The types of the checks.
+const (
+	// CheckStatus checks the status code against comma-separated codes and ranges,
+	// such as "200,201", "200-299" or "2xx,304".
+	CheckStatus = "status"
+	// CheckBody checks that the response body contains a string.
+	CheckBody = "body"
+	// CheckBodyRegexp checks that the response body matches a regular expression.
+	CheckBodyRegexp = "regexp"
+	// CheckJSON checks that the value at a JSONPath of the response body equals Value,
+	// or that it exists if Value is empty.
+	CheckJSON = "json"
+	// CheckHeader checks that a response header equals Value,
+	// or that it is present if Value is empty.
+	CheckHeader = "header"
+	// CheckDuration checks that the request duration is at most a duration, such as "300ms".
+	CheckDuration = "duration"
+	// CheckSize checks the response body size in bytes against a range, such as "1-", "-1024" or "100-2048".
+	CheckSize = "size"
+)
+
+type (
+	// Check is an assertion on the response of a request. A request that fails
+	// a check is a failed request, reported apart from the requests without response.
+	Check struct {
+		// Name is the name of the check in the report, default value is its type and expression.
+		Name string
+		// Type is the type of the check, CheckStatus, CheckBody, CheckBodyRegexp,
+		// CheckJSON, CheckHeader, CheckDuration or CheckSize.
+		Type string
+		// Expr is the expression of the check, as described by its type.
+		Expr string
+		// Value is the expected value of CheckJSON and CheckHeader.
+		Value string
+
+		ranges   []checkRange
+		re       *regexp.Regexp
+		path     []jsonPathElem
+		duration time.Duration
+	}
+	// checkRange is an inclusive range of status codes or sizes.
+	checkRange struct {
+		low  int64
+		high int64
+	}
+)
+
+// init checks the check and compiles its expression.
+func (c *Check) init() error {
+	var err error
+	switch c.Type {
+	case CheckStatus:
+		c.ranges, err = parseCheckRanges(c.Expr, true)
+	case CheckSize:
+		c.ranges, err = parseCheckRanges(c.Expr, false)
+	case CheckBody, CheckHeader:
+		if c.Expr == "" {
+			err = errors.New("Check Expr cannot be empty")
+		}
+	case CheckBodyRegexp:
+		c.re, err = regexp.Compile(c.Expr)
+	case CheckJSON:
+		c.path, err = parseJSONPath(c.Expr)
+	case CheckDuration:
+		c.duration, err = time.ParseDuration(c.Expr)
+	default:
+		err = errors.New("Check Type must be status, body, regexp, json, header, duration or size")
+	}
+	if err != nil {
+		return err
+	}
+	if c.Name == "" {
+		c.Name = c.Type + " " + c.Expr
+		if c.Value != "" {
+			c.Name += " == " + c.Value
+		}
+	}
+	return nil
+}
+
+// checksBody reports whether a check needs the response body.
+func checksBody(checks []*Check) bool {
+	for _, c := range checks {
+		switch c.Type {
+		case CheckBody, CheckBodyRegexp, CheckJSON, CheckSize:
+			return true
+		}
+	}
+	return false
+}
+
+// runChecks returns whether each check passes for the response, whose body
+// is only read if a check needs it, and the duration of the request.
+func runChecks(checks []*Check, res *http.Response, body []byte, d time.Duration) []bool {
+	passed := make([]bool, len(checks))
+	for i, c := range checks {
+		passed[i] = c.pass(res, body, d)
+	}
+	return passed
+}
+
+func (c *Check) pass(res *http.Response, body []byte, d time.Duration) bool {
+	switch c.Type {
+	case CheckStatus:
+		return inCheckRanges(c.ranges, int64(res.StatusCode))
+	case CheckSize:
+		return inCheckRanges(c.ranges, int64(len(body)))
+	case CheckBody:
+		return bytes.Contains(body, []byte(c.Expr))
+	case CheckBodyRegexp:
+		return c.re.Match(body)
+	case CheckJSON:
+		v, ok := jsonPathValue(body, c.path)
+		return ok && (c.Value == "" || v == c.Value)
+	case CheckHeader:
+		values, ok := res.Header[http.CanonicalHeaderKey(c.Expr)]
+		if !ok {
+			return false
+		}
+		if c.Value == "" {
+			return true
+		}
+		for _, v := range values {
+			if v == c.Value {
+				return true
+			}
+		}
+		return false
+	case CheckDuration:
+		return d <= c.duration
+	}
+	return false
+}
+
+// parseCheckRanges parses comma-separated values and ranges, such as "200,300-399",
+// a range can be open such as "1-" or "-1024", and status ranges can be written as "2xx".
+func parseCheckRanges(expr string, status bool) ([]checkRange, error) {
+	var ranges []checkRange
+	for _, s := range strings.Split(expr, ",") {
+		s = strings.TrimSpace(s)
+		if status && len(s) == 3 && strings.HasSuffix(strings.ToLower(s), "xx") && s[0] >= '1' && s[0] <= '5' {
+			low := int64(s[0]-'0') * 100
+			ranges = append(ranges, checkRange{low: low, high: low + 99})
+			continue
+		}
+		r := checkRange{low: 0, high: -1}
+		var err error
+		if i := strings.Index(s, "-"); i >= 0 {
+			if low := s[:i]; low != "" {
+				r.low, err = strconv.ParseInt(low, 10, 64)
+			}
+			if high := s[i+1:]; err == nil && high != "" {
+				r.high, err = strconv.ParseInt(high, 10, 64)
+			}
+			if s == "-" {
+				err = errors.New("empty range")
+			}
+		} else {
+			r.low, err = strconv.ParseInt(s, 10, 64)
+			r.high = r.low
+		}
+		if err != nil || r.low < 0 || (r.high >= 0 && r.high < r.low) {
+			return nil, fmt.Errorf("could not parse the provided range; input = %v", s)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// inCheckRanges reports whether v is in one of the ranges, a negative high is unbounded.
+func inCheckRanges(ranges []checkRange, v int64) bool {
+	for _, r := range ranges {
+		if v >= r.low && (r.high < 0 || v <= r.high) {
+			return true
+		}
+	}
+	return false
+}
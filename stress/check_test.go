@@ -0,0 +1,61 @@
+package stress
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCheckRanges(t *testing.T) {
+	ranges, err := parseCheckRanges("2xx, 304,400-404", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for v, want := range map[int64]bool{199: false, 200: true, 299: true, 304: true, 305: false, 402: true, 405: false} {
+		if inCheckRanges(ranges, v) != want {
+			t.Errorf("TestCheckRanges error, %d in ranges is %v", v, !want)
+		}
+	}
+	ranges, _ = parseCheckRanges("-10,100-", false)
+	for v, want := range map[int64]bool{0: true, 10: true, 11: false, 99: false, 1 << 40: true} {
+		if inCheckRanges(ranges, v) != want {
+			t.Errorf("TestCheckRanges error, %d in ranges is %v", v, !want)
+		}
+	}
+	for _, expr := range []string{"", "-", "abc", "300-200", "6xx"} {
+		if _, err := parseCheckRanges(expr, true); err == nil {
+			t.Errorf("TestCheckRanges error, %q passed parsing", expr)
+		}
+	}
+}
+
+func TestCheckPass(t *testing.T) {
+	res := &http.Response{StatusCode: 200, Header: http.Header{"Content-Type": {"application/json"}}}
+	body := []byte(`{"code": 0, "message": "ok"}`)
+	for _, c := range []struct {
+		check *Check
+		want  bool
+	}{
+		{&Check{Type: CheckStatus, Expr: "2xx"}, true},
+		{&Check{Type: CheckStatus, Expr: "201"}, false},
+		{&Check{Type: CheckBody, Expr: `"ok"`}, true},
+		{&Check{Type: CheckBodyRegexp, Expr: `"code":\s*1`}, false},
+		{&Check{Type: CheckJSON, Expr: "$.code", Value: "0"}, true},
+		{&Check{Type: CheckJSON, Expr: "$.data"}, false},
+		{&Check{Type: CheckHeader, Expr: "content-type"}, true},
+		{&Check{Type: CheckHeader, Expr: "Content-Type", Value: "text/html"}, false},
+		{&Check{Type: CheckDuration, Expr: "100ms"}, true},
+		{&Check{Type: CheckSize, Expr: "100-"}, false},
+	} {
+		if err := c.check.init(); err != nil {
+			t.Errorf("TestCheckPass error, %s: %v", c.check.Name, err)
+			continue
+		}
+		if c.check.pass(res, body, 50*time.Millisecond) != c.want {
+			t.Errorf("TestCheckPass error, %s passed is %v", c.check.Name, !c.want)
+		}
+	}
+	if err := (&Check{Type: "xml", Expr: "/a"}).init(); err == nil {
+		t.Errorf("TestCheckPass error, an unknown type passed")
+	}
+}
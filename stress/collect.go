@@ -0,0 +1,90 @@
+package stress
+
+import "context"
+
+// Collect is RunTranContext for the transactions run elsewhere, such as by agents
+// on other machines: instead of sending requests, it records the results received
+// until results is closed or the task is stopped. The configs describe the requests
+// of the results, and the Start of the results is expected in the local time.
+func (t *Task) Collect(ctx context.Context, results <-chan *Result, configs ...*RequestConfig) (*RunResult, error) {
+	r := &runner{
+		Task:       t,
+		reqConfigs: append([]*RequestConfig(nil), configs...),
+		source:     results,
+		ctx:        ctx,
+	}
+	if err := r.checkAndInitConfigs(); err != nil {
+		return nil, err
+	}
+	return r.run()
+}
+
+// CollectMix is Collect for the transactions of a mix of scenarios,
+// the Scenario of the results is the index of their scenario.
+func (t *Task) CollectMix(ctx context.Context, results <-chan *Result, scenarios ...*Scenario) (*RunResult, error) {
+	m, configs, err := newMix(scenarios)
+	if err != nil {
+		return nil, err
+	}
+	r := &runner{
+		Task:       t,
+		reqConfigs: configs,
+		mix:        m,
+		source:     results,
+		ctx:        ctx,
+	}
+	if err := r.checkAndInitConfigs(); err != nil {
+		return nil, err
+	}
+	return r.run()
+}
+
+// collect records the results of the source until it is closed or the task is stopped,
+// the results that do not match the requests of the task are dropped.
+func (r *runner) collect() {
+	s := r.workerStats(0)
+	for {
+		select {
+		case <-r.stopped:
+			return
+		case result, ok := <-r.source:
+			if !ok {
+				return
+			}
+			if result == nil {
+				continue
+			}
+			first, steps := 0, len(r.reqConfigs)
+			if r.mix != nil {
+				if result.Scenario < 0 || result.Scenario >= len(r.mix.scenarios) {
+					continue
+				}
+				first, steps = r.mix.steps(result.Scenario)
+			} else {
+				result.Scenario = 0
+			}
+			if !r.validResult(result, first, steps) {
+				continue
+			}
+			result.step = first
+			r.record(s, result)
+		}
+	}
+}
+
+// validResult reports whether result is a transaction of the steps requests from first,
+// started since the start of the task.
+func (r *runner) validResult(result *Result, first, steps int) bool {
+	if len(result.Details) == 0 || result.Stage < 0 || result.Start.IsZero() || result.Start.Before(r.start) {
+		return false
+	}
+	for _, res := range result.Details {
+		if res == nil || res.Step < 0 || res.Step >= steps {
+			return false
+		}
+		if len(res.Checks) > len(r.reqConfigs[first+res.Step].Checks) {
+			return false
+		}
+	}
+	return true
+}
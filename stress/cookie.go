@@ -0,0 +1,48 @@
+package stress
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+)
+
+// The lifetimes of the cookie jar of a requester.
+const (
+	// CookiesTransaction keeps the cookies for the steps of a transaction,
+	// each transaction starts with an empty jar.
+	CookiesTransaction = "transaction"
+	// CookiesUser keeps the cookies across the transactions of a requester,
+	// as a browser session of a virtual user.
+	CookiesUser = "user"
+)
+
+// cookieJar returns the cookie jar of the next transaction of vu,
+// it is nil if the cookies are not kept.
+func (r *runner) cookieJar(vu *VU) http.CookieJar {
+	switch r.Cookies {
+	case CookiesTransaction:
+		jar, _ := cookiejar.New(nil)
+		return jar
+	case CookiesUser:
+		if vu.jar == nil {
+			vu.jar, _ = cookiejar.New(nil)
+		}
+		return vu.jar
+	}
+	return nil
+}
+
+// ResetCookies clears the cookies kept across the transactions of vu with CookiesUser,
+// such as on a logout. The next transaction of vu starts with an empty jar.
+func (vu *VU) ResetCookies() {
+	vu.jar = nil
+}
+
+// withJar returns client with the cookie jar, client itself if jar is nil.
+func withJar(client *http.Client, jar http.CookieJar) *http.Client {
+	if jar == nil {
+		return client
+	}
+	c := *client
+	c.Jar = jar
+	return &c
+}
@@ -5,11 +5,24 @@ import (
 )
 
 // Events is the custom event in the request.
+// The transaction events are those of the first request of the transaction.
 type Events struct {
 	// RequestBefore is function before the request.
 	RequestBefore func(req *Request, share Share)
 	// ResponseAfter is function after the response.
 	ResponseAfter func(res *http.Response, share Share)
+	// OnResponse is function after the response, with the request,
+	// it can fail the request with Fail, such as on an unexpected body.
+	OnResponse func(req *Request, res *http.Response, share Share)
+	// OnError is function after a request that failed without a valid response,
+	// such as on a timeout, a connection error, a failed extractor or Fail in OnResponse.
+	OnError func(req *Request, err error, share Share)
+	// TransactionStart is function before the first request of the transaction.
+	TransactionStart func(share Share)
+	// TransactionEnd is function after the last request of the transaction, also
+	// when its requests are skipped or it is interrupted. The result is recorded
+	// after it, so it can change the details, such as to classify their errors.
+	TransactionEnd func(result *Result, share Share)
 }
 
 // Share is a container that is shared in the current transaction,
@@ -24,4 +37,22 @@ type Request struct {
 	GoRoutineNo int
 	// Index is current executed index.
 	Index int
+	// VU is the virtual user of the transaction, with its Store.
+	VU *VU
+
+	err  error
+	skip bool
+}
+
+// Fail fails the request with err, such as to classify the error in OnError.
+// In RequestBefore, the request is not sent. In OnResponse, OnError is called with err.
+func (r *Request) Fail(err error) {
+	r.err = err
+}
+
+// Skip skips the remaining requests of the transaction, they are not sent
+// and are counted apart in the Skipped of their step. In RequestBefore,
+// the request is skipped too.
+func (r *Request) Skip() {
+	r.skip = true
 }
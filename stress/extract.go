@@ -0,0 +1,217 @@
+package stress
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The sources of the extracted values.
+const (
+	// ExtractJSON extracts the value at a JSONPath of the response body, such as "$.data.token".
+	ExtractJSON = "json"
+	// ExtractRegexp extracts the first match of a regular expression over the response body,
+	// the value is the first group if the expression has one.
+	ExtractRegexp = "regexp"
+	// ExtractHeader extracts a response header.
+	ExtractHeader = "header"
+	// ExtractCookie extracts a cookie set by the response.
+	ExtractCookie = "cookie"
+)
+
+type (
+	// Extractor stores a value of the response into the Share of the transaction,
+	// so that the next requests can use it. If the value is not found and
+	// there is no Default, the request fails.
+	Extractor struct {
+		// Name is the key of the value in the Share.
+		Name string
+		// Source is where the value is taken from, ExtractJSON, ExtractRegexp,
+		// ExtractHeader or ExtractCookie.
+		Source string
+		// Expr is the JSONPath, the regular expression, the header name or the cookie name.
+		Expr string
+		// Default is the value stored if the value is not found.
+		Default string
+
+		re   *regexp.Regexp
+		path []jsonPathElem
+	}
+	// jsonPathElem is an object key or an array index of a JSONPath.
+	jsonPathElem struct {
+		key   string
+		index int
+	}
+)
+
+// init checks the extractor and compiles its expression.
+func (e *Extractor) init() error {
+	if e.Name == "" {
+		return errors.New("Extractor Name cannot be empty")
+	}
+	switch e.Source {
+	case ExtractJSON:
+		path, err := parseJSONPath(e.Expr)
+		if err != nil {
+			return err
+		}
+		e.path = path
+	case ExtractRegexp:
+		re, err := regexp.Compile(e.Expr)
+		if err != nil {
+			return err
+		}
+		e.re = re
+	case ExtractHeader, ExtractCookie:
+		if e.Expr == "" {
+			return errors.New("Extractor Expr cannot be empty")
+		}
+	default:
+		return errors.New("Extractor Source must be json, regexp, header or cookie")
+	}
+	return nil
+}
+
+// extract stores the values of the response into share, body is the response body
+// and is only read if an extractor needs it.
+func extract(extractors []*Extractor, res *http.Response, body []byte, share Share) error {
+	for _, e := range extractors {
+		value, ok := e.value(res, body)
+		if !ok {
+			if e.Default == "" {
+				return fmt.Errorf("Extractor %s found no value for %s", e.Name, e.Expr)
+			}
+			value = e.Default
+		}
+		share[e.Name] = value
+	}
+	return nil
+}
+
+// readsBody reports whether an extractor needs the response body.
+func readsBody(extractors []*Extractor) bool {
+	for _, e := range extractors {
+		if e.Source == ExtractJSON || e.Source == ExtractRegexp {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Extractor) value(res *http.Response, body []byte) (string, bool) {
+	switch e.Source {
+	case ExtractJSON:
+		return jsonPathValue(body, e.path)
+	case ExtractRegexp:
+		match := e.re.FindSubmatch(body)
+		if match == nil {
+			return "", false
+		}
+		if len(match) > 1 {
+			return string(match[1]), true
+		}
+		return string(match[0]), true
+	case ExtractHeader:
+		values, ok := res.Header[http.CanonicalHeaderKey(e.Expr)]
+		if !ok || len(values) == 0 {
+			return "", false
+		}
+		return values[0], true
+	case ExtractCookie:
+		for _, cookie := range res.Cookies() {
+			if cookie.Name == e.Expr {
+				return cookie.Value, true
+			}
+		}
+	}
+	return "", false
+}
+
+// parseJSONPath parses the JSONPath subset of object keys and array indexes,
+// such as "$.items[0].id" or "$['user-name']".
+func parseJSONPath(expr string) ([]jsonPathElem, error) {
+	if !strings.HasPrefix(expr, "$") {
+		return nil, fmt.Errorf("could not parse the provided JSONPath; input = %v", expr)
+	}
+	var path []jsonPathElem
+	s := expr[1:]
+	for s != "" {
+		switch {
+		case s[0] == '.':
+			end := strings.IndexAny(s[1:], ".[")
+			if end < 0 {
+				end = len(s) - 1
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("could not parse the provided JSONPath; input = %v", expr)
+			}
+			path = append(path, jsonPathElem{key: s[1 : end+1], index: -1})
+			s = s[end+1:]
+		case s[0] == '[':
+			end := strings.Index(s, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("could not parse the provided JSONPath; input = %v", expr)
+			}
+			inner := s[1:end]
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				path = append(path, jsonPathElem{key: inner[1 : len(inner)-1], index: -1})
+			} else {
+				index, err := strconv.Atoi(inner)
+				if err != nil || index < 0 {
+					return nil, fmt.Errorf("could not parse the provided JSONPath; input = %v", expr)
+				}
+				path = append(path, jsonPathElem{index: index})
+			}
+			s = s[end+1:]
+		default:
+			return nil, fmt.Errorf("could not parse the provided JSONPath; input = %v", expr)
+		}
+	}
+	return path, nil
+}
+
+// jsonPathValue returns the value at path in body, strings are returned
+// unquoted and other values as JSON.
+func jsonPathValue(body []byte, path []jsonPathElem) (string, bool) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return "", false
+	}
+	for _, elem := range path {
+		if elem.index >= 0 {
+			a, ok := v.([]interface{})
+			if !ok || elem.index >= len(a) {
+				return "", false
+			}
+			v = a[elem.index]
+			continue
+		}
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if v, ok = m[elem.key]; !ok {
+			return "", false
+		}
+	}
+	switch v := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", false
+	}
+	return string(b), true
+}
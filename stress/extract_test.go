@@ -0,0 +1,36 @@
+package stress
+
+import (
+	"testing"
+)
+
+func TestJSONPath(t *testing.T) {
+	body := []byte(`{"a": {"b-c": [1, {"d": "e"}], "f": true, "g": null}, "h": 1.5}`)
+	for expr, want := range map[string]string{
+		"$.a['b-c'][1].d": "e",
+		`$["a"].f`:        "true",
+		"$.a.b-c[0]":      "1",
+		"$.a.b-c[1]":      `{"d":"e"}`,
+		"$.h":             "1.5",
+	} {
+		path, err := parseJSONPath(expr)
+		if err != nil {
+			t.Errorf("TestJSONPath error, %s: %v", expr, err)
+			continue
+		}
+		if v, ok := jsonPathValue(body, path); !ok || v != want {
+			t.Errorf("TestJSONPath error, %s = %q, want %q", expr, v, want)
+		}
+	}
+	for _, expr := range []string{"$.a.g", "$.a.b-c[2]", "$.h.i", "$.x"} {
+		path, _ := parseJSONPath(expr)
+		if v, ok := jsonPathValue(body, path); ok {
+			t.Errorf("TestJSONPath error, %s = %q, want no value", expr, v)
+		}
+	}
+	for _, expr := range []string{"a.b", "$.", "$[x]", "$.a[0", "$..a"} {
+		if _, err := parseJSONPath(expr); err == nil {
+			t.Errorf("TestJSONPath error, %s passed parsing", expr)
+		}
+	}
+}
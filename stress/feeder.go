@@ -0,0 +1,163 @@
+package stress
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The modes of consumption of the rows of a Feeder.
+const (
+	// FeedSequential hands the rows in order, and starts over when they are exhausted.
+	FeedSequential = "sequential"
+	// FeedRandom hands random rows.
+	FeedRandom = "random"
+	// FeedPartitioned splits the rows between the requesters, each requester
+	// hands its own rows in order, and starts over when they are exhausted.
+	FeedPartitioned = "partitioned"
+	// FeedOnce hands the rows in order, and stops the task when they are exhausted.
+	FeedOnce = "once"
+)
+
+type (
+	// Feeder hands each transaction a row of data, the columns of the row are
+	// stored in the Share of the transaction before the first request, so the
+	// templates can use them, such as {{.Share.user_id}}.
+	Feeder struct {
+		// Rows is the data, each row maps a column to its value.
+		Rows []map[string]interface{}
+		// Mode is the consumption of the rows, FeedSequential, FeedRandom,
+		// FeedPartitioned or FeedOnce. Default value is FeedSequential.
+		Mode string
+	}
+	// feed is the consumption of the rows of a Feeder by a run.
+	feed struct {
+		*Feeder
+		mx         sync.Mutex
+		next       int
+		partitions []int
+		rand       *rand.Rand
+	}
+)
+
+// LoadFeeder reads the rows of a CSV file, whose first line is the column names,
+// or of a JSONL file, one JSON object per line, by the file extension.
+func LoadFeeder(path string) (*Feeder, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var rows []map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		rows, err = readCSVRows(file)
+	case ".jsonl", ".ndjson":
+		rows, err = readJSONLRows(file)
+	default:
+		return nil, fmt.Errorf("data file must be .csv or .jsonl; input = %v", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Feeder{Rows: rows}, nil
+}
+
+func readCSVRows(r io.Reader) ([]map[string]interface{}, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	columns := records[0]
+	var rows []map[string]interface{}
+	for _, record := range records[1:] {
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			if i < len(record) {
+				row[column] = record[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func readJSONLRows(r io.Reader) ([]map[string]interface{}, error) {
+	var rows []map[string]interface{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		b := bytes.TrimSpace(scanner.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		var row map[string]interface{}
+		if err := dec.Decode(&row); err != nil {
+			return nil, fmt.Errorf("could not parse the data line %d: %v", line, err)
+		}
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
+
+// newFeed checks the feeder and starts its consumption by the requesters of a run.
+func (f *Feeder) newFeed(requesters int) (*feed, error) {
+	if len(f.Rows) == 0 {
+		return nil, errors.New("Feeder Rows cannot be empty")
+	}
+	switch f.Mode {
+	case "", FeedSequential, FeedRandom, FeedOnce:
+	case FeedPartitioned:
+		if len(f.Rows) < requesters {
+			return nil, errors.New("Feeder Rows cannot be fewer than the requesters when partitioned")
+		}
+	default:
+		return nil, errors.New("Feeder Mode must be sequential, random, partitioned or once")
+	}
+	return &feed{
+		Feeder:     f,
+		partitions: make([]int, requesters),
+		rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// row returns the row of the next transaction of the requester no,
+// false if the rows are exhausted.
+func (f *feed) row(no int) (map[string]interface{}, bool) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	n := len(f.Rows)
+	switch f.Mode {
+	case FeedRandom:
+		return f.Rows[f.rand.Intn(n)], true
+	case FeedPartitioned:
+		requesters := len(f.partitions)
+		no %= requesters
+		low, high := no*n/requesters, (no+1)*n/requesters
+		i := low + f.partitions[no]%(high-low)
+		f.partitions[no]++
+		return f.Rows[i], true
+	case FeedOnce:
+		if f.next >= n {
+			return nil, false
+		}
+	}
+	i := f.next % n
+	f.next++
+	return f.Rows[i], true
+}
@@ -0,0 +1,67 @@
+package stress
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadFeeder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "users.csv"), []byte("id,term\n1,\"a,b\"\n2,c\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "users.jsonl"), []byte("{\"id\": 1, \"term\": \"a,b\"}\n\n{\"id\": 2, \"term\": \"c\"}\n"), 0644)
+	for _, name := range []string{"users.csv", "users.jsonl"} {
+		f, err := LoadFeeder(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("TestLoadFeeder error, %s: %v", name, err)
+			continue
+		}
+		if len(f.Rows) != 2 || f.Rows[0]["term"] != "a,b" || f.Rows[1]["term"] != "c" {
+			t.Errorf("TestLoadFeeder error, %s rows %v", name, f.Rows)
+		}
+	}
+	f, _ := LoadFeeder(filepath.Join(dir, "users.jsonl"))
+	if f.Rows[1]["id"] != json.Number("2") {
+		t.Errorf("TestLoadFeeder error, id %#v", f.Rows[1]["id"])
+	}
+	if _, err := LoadFeeder(filepath.Join(dir, "users.txt")); err == nil {
+		t.Errorf("TestLoadFeeder error, an unknown extension passed")
+	}
+}
+
+func TestFeederModes(t *testing.T) {
+	rows := []map[string]interface{}{{"i": 0}, {"i": 1}, {"i": 2}, {"i": 3}}
+	f, err := (&Feeder{Rows: rows, Mode: FeedPartitioned}).newFeed(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []int{0, 1, 0} {
+		if row, _ := f.row(0); row["i"] != want {
+			t.Errorf("TestFeederModes error, partitioned row %v, want %d", row, want)
+		}
+	}
+	if row, _ := f.row(1); row["i"] != 2 {
+		t.Errorf("TestFeederModes error, partitioned row %v, want 2", row)
+	}
+	f, _ = (&Feeder{Rows: rows, Mode: FeedOnce}).newFeed(2)
+	for i := 0; i < 4; i++ {
+		if row, ok := f.row(i % 2); !ok || row["i"] != i {
+			t.Errorf("TestFeederModes error, once row %v, want %d", row, i)
+		}
+	}
+	if _, ok := f.row(0); ok {
+		t.Errorf("TestFeederModes error, once rows not exhausted")
+	}
+	if _, err := (&Feeder{Rows: rows, Mode: FeedPartitioned}).newFeed(5); err == nil {
+		t.Errorf("TestFeederModes error, more requesters than rows passed")
+	}
+	if _, err := (&Feeder{Rows: rows, Mode: "cycle"}).newFeed(1); err == nil {
+		t.Errorf("TestFeederModes error, an unknown mode passed")
+	}
+}
@@ -0,0 +1,52 @@
+package stress
+
+import "errors"
+
+// The reasons a transaction is aborted before its end, besides a jump to an unknown step.
+const (
+	// AbortFailure aborts the transaction at a failed request with AbortOnFailure.
+	AbortFailure = "failed request"
+	// AbortStopped aborts the transaction repeating or looping when the task is stopped.
+	AbortStopped = "task stopped"
+)
+
+// checkFlow checks the control flow of the requests of a transaction.
+func checkFlow(configs []*RequestConfig) error {
+	names := make(map[string]bool)
+	for _, config := range configs {
+		if config.Repeat < 0 {
+			return errors.New("Repeat cannot be smaller than 0")
+		}
+		if config.Name == "" {
+			continue
+		}
+		if names[config.Name] {
+			return errors.New("Name must be unique in a transaction")
+		}
+		names[config.Name] = true
+	}
+	return nil
+}
+
+// stepIndex returns the index of the request named name in configs, -1 if there is none.
+func stepIndex(configs []*RequestConfig, name string) int {
+	for i, config := range configs {
+		if config.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// skipRequests appends the details of the skipped requests of configs, the first of which is the request step.
+func skipRequests(details []*ResultDetail, configs []*RequestConfig, step int) []*ResultDetail {
+	for i, config := range configs {
+		details = append(details, &ResultDetail{
+			URLStr:  config.URLStr,
+			Method:  config.Method,
+			Step:    step + i,
+			Skipped: true,
+		})
+	}
+	return details
+}
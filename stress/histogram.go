@@ -0,0 +1,156 @@
+package stress
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// histogramSubCount is the number of exact values below which values are
+	// counted one by one, above it values are counted in buckets with a
+	// relative width below 2/histogramSubCount.
+	histogramSubCount  = 128
+	histogramHalfCount = histogramSubCount / 2
+)
+
+// histogram is an HDR-style histogram of durations with microsecond resolution.
+// Its memory depends on the largest recorded value, not on the number of values.
+type histogram struct {
+	counts []int64
+	count  int64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+// record adds a duration to the histogram.
+func (h *histogram) record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	i := histogramIndex(int64(d / time.Microsecond))
+	if i >= len(h.counts) {
+		counts := make([]int64, i+histogramHalfCount)
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	h.counts[i]++
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.count++
+	h.sum += d
+}
+
+// merge adds all the values of o to the histogram.
+func (h *histogram) merge(o *histogram) {
+	if o.count == 0 {
+		return
+	}
+	if len(o.counts) > len(h.counts) {
+		counts := make([]int64, len(o.counts))
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	for i, c := range o.counts {
+		h.counts[i] += c
+	}
+	if h.count == 0 || o.min < h.min {
+		h.min = o.min
+	}
+	if o.max > h.max {
+		h.max = o.max
+	}
+	h.count += o.count
+	h.sum += o.sum
+}
+
+// reset removes all the values of the histogram.
+func (h *histogram) reset() {
+	for i := range h.counts {
+		h.counts[i] = 0
+	}
+	h.count = 0
+	h.sum = 0
+	h.min = 0
+	h.max = 0
+}
+
+// mean returns the average of the recorded values.
+func (h *histogram) mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.count)
+}
+
+// quantile returns the value at or below which the fraction q of the recorded values are.
+func (h *histogram) quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	if q <= 0 {
+		return h.min
+	}
+	if q >= 1 {
+		return h.max
+	}
+	rank := int64(math.Ceil(q * float64(h.count)))
+	var seen int64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			return h.clamp(histogramValue(i))
+		}
+	}
+	return h.max
+}
+
+// each calls fn for each non-empty bucket with its lowest value and its count.
+func (h *histogram) each(fn func(d time.Duration, count int64)) {
+	for i, c := range h.counts {
+		if c == 0 {
+			continue
+		}
+		var low time.Duration
+		if i > 0 {
+			low = histogramValue(i-1) + 1
+		}
+		fn(h.clamp(low), c)
+	}
+}
+
+func (h *histogram) clamp(d time.Duration) time.Duration {
+	if d < h.min {
+		return h.min
+	}
+	if d > h.max {
+		return h.max
+	}
+	return d
+}
+
+// histogramIndex returns the index of the bucket counting v microseconds.
+func histogramIndex(v int64) int {
+	if v < histogramSubCount {
+		return int(v)
+	}
+	var shift uint
+	for v>>shift >= histogramSubCount {
+		shift++
+	}
+	return int(shift)*histogramHalfCount + int(v>>shift)
+}
+
+// histogramValue returns the highest duration counted in the bucket at index i.
+func histogramValue(i int) time.Duration {
+	if i < histogramSubCount {
+		return time.Duration(i+1)*time.Microsecond - 1
+	}
+	shift := uint(i/histogramHalfCount - 1)
+	sub := int64(i - int(shift)*histogramHalfCount)
+	return time.Duration((sub+1)<<shift)*time.Microsecond - 1
+}
@@ -0,0 +1,50 @@
+package stress
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistogramIndex(t *testing.T) {
+	for _, v := range []int64{0, 1, 127, 128, 129, 255, 256, 1000, 123456, 1 << 40} {
+		i := histogramIndex(v)
+		high := int64(histogramValue(i) / time.Microsecond)
+		if high < v {
+			t.Errorf("TestHistogramIndex error, %d counted in bucket %d up to %d", v, i, high)
+		}
+		if i > 0 {
+			low := int64(histogramValue(i-1)/time.Microsecond) + 1
+			if low > v {
+				t.Errorf("TestHistogramIndex error, %d counted in bucket %d from %d", v, i, low)
+			}
+		}
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	var h, other histogram
+	for i := 1; i <= 1000; i++ {
+		if i%2 == 0 {
+			h.record(time.Duration(i) * time.Millisecond)
+		} else {
+			other.record(time.Duration(i) * time.Millisecond)
+		}
+	}
+	h.merge(&other)
+	if h.count != 1000 || h.min != time.Millisecond || h.max != time.Second {
+		t.Fatalf("TestHistogramQuantile error, count %d min %v max %v", h.count, h.min, h.max)
+	}
+	if mean := h.mean(); mean != 500500*time.Microsecond {
+		t.Errorf("TestHistogramQuantile error, mean %v", mean)
+	}
+	for _, q := range []float64{0.5, 0.9, 0.99, 0.999} {
+		want := time.Duration(q*1000) * time.Millisecond
+		got := h.quantile(q)
+		if got < want || float64(got-want) > float64(want)*0.02 {
+			t.Errorf("TestHistogramQuantile error, quantile %v is %v, want %v", q, got, want)
+		}
+	}
+	if h.quantile(1) != time.Second {
+		t.Errorf("TestHistogramQuantile error, max quantile %v", h.quantile(1))
+	}
+}
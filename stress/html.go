@@ -0,0 +1,315 @@
+package stress
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	chartWidth  = 760
+	chartHeight = 240
+	chartPad    = 56
+)
+
+// curvePercentiles is the percentiles of the latency curves in the HTML report.
+var curvePercentiles = []float64{0, 25, 50, 75, 90, 95, 99, 99.5, 99.9, 99.95, 99.99, 99.999}
+
+type (
+	// chartSeries is a line of a chart.
+	chartSeries struct {
+		name   string
+		color  string
+		points [][2]float64
+	}
+	// chartTick is a labelled position on the x axis of a chart.
+	chartTick struct {
+		x     float64
+		label string
+	}
+	// htmlReport is the data of the HTML report template.
+	htmlReport struct {
+		*Report
+		ThroughputChart template.HTML
+		LatencyChart    template.HTML
+		PercentileChart template.HTML
+		HistogramChart  template.HTML
+	}
+	// htmlPhase is a row of a phase latency table.
+	htmlPhase struct {
+		Name    string
+		Latency *Latency
+	}
+	// htmlCount is a row of a status code or error table.
+	htmlCount struct {
+		Name  string
+		Count int
+	}
+)
+
+// writeHTML writes the report with its charts to index.html in the output directory.
+// The page has no external assets, so it can be opened offline.
+func (r *Report) writeHTML(output string) error {
+	file, err := os.Create(filepath.Join(output, "index.html"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return htmlTemplate.Execute(file, &htmlReport{
+		Report:          r,
+		ThroughputChart: r.throughputChart(),
+		LatencyChart:    r.latencyChart(),
+		PercentileChart: r.percentileChart(),
+		HistogramChart:  r.histogramChart(),
+	})
+}
+
+func (r *Report) throughputChart() template.HTML {
+	total := chartSeries{name: "transactions/sec", color: "#1f77b4"}
+	errs := chartSeries{name: "errors/sec", color: "#d62728"}
+	for _, point := range r.Timeline {
+		total.points = append(total.points, [2]float64{point.Start, point.RPS})
+		errs.points = append(errs.points, [2]float64{point.Start, float64(point.Errors) / r.Config.Interval})
+	}
+	return lineChart([]chartSeries{total, errs}, r.timeTicks(), "%.0f")
+}
+
+func (r *Report) latencyChart() template.HTML {
+	avg := chartSeries{name: "average (secs)", color: "#1f77b4"}
+	max := chartSeries{name: "slowest (secs)", color: "#ff7f0e"}
+	for _, point := range r.Timeline {
+		if point.Transactions == 0 {
+			continue
+		}
+		avg.points = append(avg.points, [2]float64{point.Start, point.Latency.Average})
+		max.points = append(max.points, [2]float64{point.Start, point.Latency.Slowest})
+	}
+	return lineChart([]chartSeries{avg, max}, r.timeTicks(), "%.4f")
+}
+
+// percentileChart draws the latency curves, the x axis is scaled by the
+// number of nines of the percentile so that the tail is readable.
+func (r *Report) percentileChart() template.HTML {
+	nines := func(p float64) float64 {
+		return -math.Log10(1 - p/100)
+	}
+	curves := []chartSeries{{name: "latency (secs)", color: "#1f77b4"}}
+	for _, p := range r.curve {
+		curves[0].points = append(curves[0].points, [2]float64{nines(p.Percentile), p.Value})
+	}
+	if r.correctedCurve != nil {
+		corrected := chartSeries{name: "corrected latency (secs)", color: "#d62728"}
+		for _, p := range r.correctedCurve {
+			corrected.points = append(corrected.points, [2]float64{nines(p.Percentile), p.Value})
+		}
+		curves = append(curves, corrected)
+	}
+	var ticks []chartTick
+	for _, p := range []float64{0, 90, 99, 99.9, 99.99, 99.999} {
+		ticks = append(ticks, chartTick{x: nines(p), label: percentileTag(p)})
+	}
+	return lineChart(curves, ticks, "%.4f")
+}
+
+func (r *Report) histogramChart() template.HTML {
+	var b bytes.Buffer
+	if len(r.Histogram) == 0 {
+		return ""
+	}
+	var max int64
+	for _, bucket := range r.Histogram {
+		if bucket.Count > max {
+			max = bucket.Count
+		}
+	}
+	fmt.Fprintf(&b, `<svg width="%d" height="%d" xmlns="http://www.w3.org/2000/svg">`, chartWidth, chartHeight)
+	plotW := float64(chartWidth - 2*chartPad)
+	plotH := float64(chartHeight - 2*chartPad)
+	barW := plotW / float64(len(r.Histogram))
+	for i, bucket := range r.Histogram {
+		h := 0.0
+		if max > 0 {
+			h = plotH * float64(bucket.Count) / float64(max)
+		}
+		x := float64(chartPad) + barW*float64(i)
+		y := float64(chartPad) + plotH - h
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="#1f77b4"><title>%d</title></rect>`, x+1, y, barW-2, h, bucket.Count)
+		fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" font-size="10" text-anchor="middle">%d</text>`, x+barW/2, y-3, bucket.Count)
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" font-size="10" text-anchor="middle">%.4f</text>`, x+barW/2, chartHeight-chartPad+14, bucket.Mark)
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// timeTicks returns about five ticks over the timeline, on whole seconds.
+func (r *Report) timeTicks() []chartTick {
+	if len(r.Timeline) == 0 {
+		return nil
+	}
+	end := r.Timeline[len(r.Timeline)-1].Start
+	step := math.Max(math.Floor(end/5), 1)
+	var ticks []chartTick
+	for s := 0.0; s <= end; s += step {
+		ticks = append(ticks, chartTick{x: s, label: fmt.Sprintf("%.0fs", s)})
+	}
+	return ticks
+}
+
+// lineChart renders series as an inline SVG chart, yFormat formats the y axis labels.
+func lineChart(series []chartSeries, ticks []chartTick, yFormat string) template.HTML {
+	var maxX, maxY float64
+	for _, s := range series {
+		for _, p := range s.points {
+			maxX = math.Max(maxX, p[0])
+			maxY = math.Max(maxY, p[1])
+		}
+	}
+	for _, tick := range ticks {
+		maxX = math.Max(maxX, tick.x)
+	}
+	if maxX == 0 {
+		maxX = 1
+	}
+	if maxY == 0 {
+		maxY = 1
+	}
+	plotW := float64(chartWidth - 2*chartPad)
+	plotH := float64(chartHeight - 2*chartPad)
+	px := func(x float64) float64 { return float64(chartPad) + plotW*x/maxX }
+	py := func(y float64) float64 { return float64(chartPad) + plotH - plotH*y/maxY }
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg width="%d" height="%d" xmlns="http://www.w3.org/2000/svg">`, chartWidth, chartHeight)
+	for i := 0; i <= 4; i++ {
+		y := maxY * float64(i) / 4
+		fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#ddd"/>`, chartPad, py(y), chartWidth-chartPad, py(y))
+		fmt.Fprintf(&b, `<text x="%d" y="%.1f" font-size="10" text-anchor="end">`+yFormat+`</text>`, chartPad-4, py(y)+3, y)
+	}
+	for _, tick := range ticks {
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" font-size="10" text-anchor="middle">%s</text>`,
+			px(tick.x), chartHeight-chartPad+14, template.HTMLEscapeString(tick.label))
+	}
+	for i, s := range series {
+		var points bytes.Buffer
+		for _, p := range s.points {
+			fmt.Fprintf(&points, "%.1f,%.1f ", px(p[0]), py(p[1]))
+		}
+		fmt.Fprintf(&b, `<polyline fill="none" stroke="%s" stroke-width="1.5" points="%s"/>`, s.color, points.String())
+		fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="11" fill="%s">%s</text>`,
+			chartPad+i*200, chartPad-12, s.color, template.HTMLEscapeString(s.name))
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"pct": percentileTag,
+	"codeRows": func(dist map[int]int) []htmlCount {
+		var rows []htmlCount
+		for code, n := range dist {
+			rows = append(rows, htmlCount{Name: fmt.Sprint(code), Count: n})
+		}
+		sort.Sort(byName(rows))
+		return rows
+	},
+	"errorRows": func(dist map[string]int) []htmlCount {
+		var rows []htmlCount
+		for err, n := range dist {
+			rows = append(rows, htmlCount{Name: err, Count: n})
+		}
+		sort.Sort(byName(rows))
+		return rows
+	},
+	"phases": func(step *StepReport) []htmlPhase {
+		return []htmlPhase{
+			{"Response Time", step.ResponseTime},
+			{"DNS+dialup", step.DNSDialup},
+			{"DNS-lookup", step.DNSLookup},
+			{"Request Before", step.RequestBefore},
+			{"Request Write", step.RequestWrite},
+			{"Response Wait", step.ResponseWait},
+			{"Response After", step.ResponseAfter},
+			{"Response Read", step.ResponseRead},
+		}
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>stress report</title>
+<style>
+body { font-family: sans-serif; margin: 24px; color: #222; }
+table { border-collapse: collapse; margin: 8px 0 16px; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: right; font-size: 13px; }
+th:first-child, td:first-child { text-align: left; }
+h2 { margin-top: 32px; }
+</style>
+</head>
+<body>
+<h1>stress report</h1>
+<table>
+<tr><td>Total</td><td>{{printf "%.4f" .Total}} secs</td></tr>
+<tr><td>Transactions</td><td>{{.Transactions}}</td></tr>
+<tr><td>Errors</td><td>{{.Errors}}</td></tr>
+{{if .AbortedTransactions}}<tr><td>Aborted transactions</td><td>{{.AbortedTransactions}}</td></tr>
+{{end}}<tr><td>Requests/sec</td><td>{{printf "%.4f" .RPS}}</td></tr>
+{{if .Aborted}}<tr><td>Aborted</td><td>{{.Aborted}}</td></tr>
+{{end}}<tr><td>Average</td><td>{{printf "%.4f" .Latency.Average}} secs</td></tr>
+<tr><td>Fastest</td><td>{{printf "%.4f" .Latency.Fastest}} secs</td></tr>
+<tr><td>Slowest</td><td>{{printf "%.4f" .Latency.Slowest}} secs</td></tr>
+{{range .Latency.Percentiles}}<tr><td>{{pct .Percentile}}</td><td>{{printf "%.4f" .Value}} secs</td></tr>
+{{end}}</table>
+{{if .Thresholds}}<h2>Thresholds</h2>
+<table>
+<tr><th>Result</th><th>Request</th><th>Threshold</th><th>Value</th></tr>
+{{range .Thresholds}}<tr><td>{{if .Passed}}PASS{{else}}FAIL{{end}}</td><td>{{if .Step}}{{.Step}}{{else}}task{{end}}</td><td>{{.Threshold}}</td><td>{{printf "%.4f" .Value}}</td></tr>
+{{end}}</table>
+{{end}}<h2>Throughput over time</h2>
+{{.ThroughputChart}}
+<h2>Latency over time</h2>
+{{.LatencyChart}}
+<h2>Latency percentiles</h2>
+{{.PercentileChart}}
+<h2>Response time histogram</h2>
+{{.HistogramChart}}
+{{if .Stages}}<h2>Stages</h2>
+<table>
+<tr><th>Stage</th><th>Start</th><th>End</th><th>Target</th><th>Transactions</th><th>Errors</th><th>Average</th><th>Requests/sec</th></tr>
+{{range $i, $s := .Stages}}<tr><td>{{$i}}</td><td>{{printf "%.1f" $s.Start}}</td><td>{{printf "%.1f" $s.End}}</td><td>{{$s.Target}}</td><td>{{$s.Transactions}}</td><td>{{$s.Errors}}</td><td>{{printf "%.4f" $s.Average}}</td><td>{{printf "%.4f" $s.RPS}}</td></tr>
+{{end}}</table>
+{{end}}{{if .Scenarios}}<h2>Scenarios</h2>
+<table>
+<tr><th>Scenario</th><th>Weight</th><th>Transactions</th><th>Errors</th><th>Average</th><th>Requests/sec</th></tr>
+{{range .Scenarios}}<tr><td>{{.Name}}</td><td>{{.Weight}}</td><td>{{.Transactions}}</td><td>{{.Errors}}</td><td>{{printf "%.4f" .Latency.Average}}</td><td>{{printf "%.4f" .RPS}}</td></tr>
+{{end}}</table>
+{{end}}<h2>Detailed report</h2>
+{{range .Steps}}<h3>{{if .Scenario}}{{.Scenario}}: {{end}}[{{.Method}}] {{.URL}}</h3>
+<table>
+<tr><th>Phase</th><th>Average</th><th>Fastest</th><th>Slowest</th>{{range .ResponseTime.Percentiles}}<th>{{pct .Percentile}}</th>{{end}}</tr>
+{{range phases .}}<tr><td>{{.Name}}</td><td>{{printf "%.4f" .Latency.Average}}</td><td>{{printf "%.4f" .Latency.Fastest}}</td><td>{{printf "%.4f" .Latency.Slowest}}</td>{{range .Latency.Percentiles}}<td>{{printf "%.4f" .Value}}</td>{{end}}</tr>
+{{end}}</table>
+<table>
+<tr><th>Status code</th><th>Responses</th></tr>
+{{range codeRows .StatusCodes}}<tr><td>{{.Name}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>
+{{if .Checks}}<table>
+<tr><th>Check</th><th>Passed</th><th>Failed</th></tr>
+{{range .Checks}}<tr><td>{{.Name}}</td><td>{{.Passed}}</td><td>{{.Failed}}</td></tr>
+{{end}}</table>
+{{end}}{{if .Errors}}<table>
+<tr><th>Error</th><th>Count</th></tr>
+{{range errorRows .Errors}}<tr><td>{{.Name}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}</body>
+</html>
+`))
+
+type byName []htmlCount
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
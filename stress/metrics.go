@@ -0,0 +1,202 @@
+package stress
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metricsBuckets is the upper bounds in seconds of the Prometheus latency histograms.
+var metricsBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type (
+	// metrics is the Prometheus metrics of a running task.
+	metrics struct {
+		mx           sync.Mutex
+		run          *runner
+		requests     map[string]int64
+		errors       map[string]int64
+		checks       map[string]int64
+		durations    map[string]*metricsHistogram
+		transactions *metricsHistogram
+		server       *http.Server
+	}
+	// metricsHistogram is a Prometheus histogram.
+	metricsHistogram struct {
+		counts []int64
+		count  int64
+		sum    float64
+	}
+)
+
+// startMetrics serves the metrics of the task on /metrics at addr until stop is called.
+func (r *runner) startMetrics(addr string) (*metrics, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	m := &metrics{
+		run:          r,
+		requests:     make(map[string]int64),
+		errors:       make(map[string]int64),
+		checks:       make(map[string]int64),
+		durations:    make(map[string]*metricsHistogram),
+		transactions: newMetricsHistogram(),
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	m.server = &http.Server{Handler: mux}
+	go m.server.Serve(ln)
+	return m, nil
+}
+
+func (m *metrics) stop() {
+	m.server.Close()
+}
+
+// add records the result of a transaction.
+func (m *metrics) add(result *Result) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.transactions.observe(result.Duration.Seconds())
+	for _, res := range result.Details {
+		step := m.run.reqConfigs[result.step+res.Step]
+		if res.Skipped {
+			continue
+		}
+		stepLabels := labels("url", step.URLStr, "method", step.Method)
+		if res.Err != nil {
+			m.errors[stepLabels+","+labels("error", errorClass(res.Err))]++
+			continue
+		}
+		m.requests[stepLabels+","+labels("code", fmt.Sprint(res.StatusCode))]++
+		for j, passed := range res.Checks {
+			if !passed {
+				m.checks[stepLabels+","+labels("check", step.Checks[j].Name)]++
+			}
+		}
+		h := m.durations[stepLabels]
+		if h == nil {
+			h = newMetricsHistogram()
+			m.durations[stepLabels] = h
+		}
+		h.observe(res.Duration.Seconds())
+	}
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var b bytes.Buffer
+	m.mx.Lock()
+	writeCounter(&b, "stress_requests_total", "Number of completed requests.", m.requests)
+	writeCounter(&b, "stress_request_errors_total", "Number of failed requests.", m.errors)
+	writeCounter(&b, "stress_check_failures_total", "Number of responses that failed a check.", m.checks)
+	fmt.Fprintf(&b, "# HELP stress_request_duration_seconds Duration of the completed requests.\n")
+	fmt.Fprintf(&b, "# TYPE stress_request_duration_seconds histogram\n")
+	var keys []string
+	for key := range m.durations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		m.durations[key].write(&b, "stress_request_duration_seconds", key)
+	}
+	fmt.Fprintf(&b, "# HELP stress_transaction_duration_seconds Duration of the completed transactions.\n")
+	fmt.Fprintf(&b, "# TYPE stress_transaction_duration_seconds histogram\n")
+	m.transactions.write(&b, "stress_transaction_duration_seconds", "")
+	m.mx.Unlock()
+	fmt.Fprintf(&b, "# HELP stress_in_flight Number of transactions in progress.\n")
+	fmt.Fprintf(&b, "# TYPE stress_in_flight gauge\n")
+	fmt.Fprintf(&b, "stress_in_flight %d\n", atomic.LoadInt64(&m.run.inFlight))
+	fmt.Fprintf(&b, "# HELP stress_elapsed_seconds Time since the start of the task.\n")
+	fmt.Fprintf(&b, "# TYPE stress_elapsed_seconds gauge\n")
+	fmt.Fprintf(&b, "stress_elapsed_seconds %g\n", time.Now().Sub(m.run.start).Seconds())
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(b.Bytes())
+}
+
+func newMetricsHistogram() *metricsHistogram {
+	return &metricsHistogram{counts: make([]int64, len(metricsBuckets))}
+}
+
+func (h *metricsHistogram) observe(v float64) {
+	for i, le := range metricsBuckets {
+		if v <= le {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *metricsHistogram) write(b *bytes.Buffer, name, lbls string) {
+	sep := ""
+	if lbls != "" {
+		sep = ","
+	}
+	for i, le := range metricsBuckets {
+		fmt.Fprintf(b, "%s_bucket{%s%sle=\"%g\"} %d\n", name, lbls, sep, le, h.counts[i])
+	}
+	fmt.Fprintf(b, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, lbls, sep, h.count)
+	fmt.Fprintf(b, "%s_sum%s %g\n", name, braces(lbls), h.sum)
+	fmt.Fprintf(b, "%s_count%s %d\n", name, braces(lbls), h.count)
+}
+
+func writeCounter(b *bytes.Buffer, name, help string, values map[string]int64) {
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s counter\n", name)
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(b, "%s{%s} %d\n", name, key, values[key])
+	}
+}
+
+// labels formats name and value pairs as Prometheus labels.
+func labels(pairs ...string) string {
+	var s []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		v := strings.Replace(pairs[i+1], `\`, `\\`, -1)
+		v = strings.Replace(v, `"`, `\"`, -1)
+		v = strings.Replace(v, "\n", `\n`, -1)
+		s = append(s, fmt.Sprintf(`%s="%s"`, pairs[i], v))
+	}
+	return strings.Join(s, ",")
+}
+
+func braces(lbls string) string {
+	if lbls == "" {
+		return ""
+	}
+	return "{" + lbls + "}"
+}
+
+// errorClass classifies a request error for the metrics labels.
+func errorClass(err error) string {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return "timeout"
+	}
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "connection refused"):
+		return "connection_refused"
+	case strings.Contains(msg, "connection reset"):
+		return "connection_reset"
+	case strings.Contains(msg, "no such host"):
+		return "dns"
+	case strings.Contains(msg, "tls:") || strings.Contains(msg, "x509:"):
+		return "tls"
+	case strings.Contains(msg, "EOF"):
+		return "eof"
+	}
+	return "other"
+}
@@ -0,0 +1,122 @@
+package stress
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type (
+	// Scenario is a named transaction of a mix, each transaction of the task
+	// runs the requests of a scenario picked by the weights of the mix.
+	Scenario struct {
+		// Name is the name of the scenario in the report.
+		Name string
+		// Weight is the share of the transactions of the scenario, relative to the
+		// weights of the other scenarios of the mix, such as 70, 25 and 5. Default value is 1.
+		Weight int
+		// Configs is the requests of the transaction of the scenario, in order.
+		Configs []*RequestConfig
+	}
+	// mix picks the scenario of each transaction of a run.
+	mix struct {
+		mx        sync.Mutex
+		rand      *rand.Rand
+		scenarios []*Scenario
+		// weights is the cumulative weights of the scenarios.
+		weights []int
+		// firsts is the index of the first request of each scenario in the requests of the run.
+		firsts []int
+	}
+)
+
+// RunMix is run a task whose transactions follow the mix of scenarios, and returns its result.
+// The report breaks the transactions down by scenario, and the requests by step of each scenario.
+func (t *Task) RunMix(scenarios ...*Scenario) (*RunResult, error) {
+	return t.RunMixContext(context.Background(), scenarios...)
+}
+
+// RunMixContext is RunMix, stopped when ctx is done as RunContext.
+func (t *Task) RunMixContext(ctx context.Context, scenarios ...*Scenario) (*RunResult, error) {
+	m, configs, err := newMix(scenarios)
+	if err != nil {
+		return nil, err
+	}
+	r := &runner{
+		Task:       t,
+		reqConfigs: configs,
+		mix:        m,
+		ctx:        ctx,
+	}
+	if err := r.checkAndInitConfigs(); err != nil {
+		return nil, err
+	}
+	return r.run()
+}
+
+// newMix checks the scenarios, and returns their mix and all their requests.
+func newMix(scenarios []*Scenario) (*mix, []*RequestConfig, error) {
+	if len(scenarios) == 0 {
+		return nil, nil, errors.New("Scenarios cannot be empty")
+	}
+	m := &mix{
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		scenarios: scenarios,
+	}
+	var configs []*RequestConfig
+	var total int
+	for _, s := range scenarios {
+		if s == nil || len(s.Configs) == 0 {
+			return nil, nil, errors.New("Scenario Configs cannot be empty")
+		}
+		if s.Weight < 0 {
+			return nil, nil, errors.New("Scenario Weight cannot be smaller than 0")
+		}
+		weight := s.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		total += weight
+		m.weights = append(m.weights, total)
+		m.firsts = append(m.firsts, len(configs))
+		configs = append(configs, s.Configs...)
+	}
+	return m, configs, nil
+}
+
+// pick returns the index of the scenario of the next transaction.
+func (m *mix) pick() int {
+	m.mx.Lock()
+	n := m.rand.Intn(m.weights[len(m.weights)-1])
+	m.mx.Unlock()
+	for i, w := range m.weights {
+		if n < w {
+			return i
+		}
+	}
+	return len(m.weights) - 1
+}
+
+// weight returns the weight of the scenario i.
+func (m *mix) weight(i int) int {
+	if i == 0 {
+		return m.weights[0]
+	}
+	return m.weights[i] - m.weights[i-1]
+}
+
+// steps returns the index of the first request of the scenario i and its number of requests.
+func (m *mix) steps(i int) (int, int) {
+	return m.firsts[i], len(m.scenarios[i].Configs)
+}
+
+// scenario returns the index of the scenario of the request step.
+func (m *mix) scenario(step int) int {
+	i := 0
+	for i+1 < len(m.firsts) && m.firsts[i+1] <= step {
+		i++
+	}
+	return i
+}
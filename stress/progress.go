@@ -0,0 +1,103 @@
+package stress
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// progressInterval is the interval between two progress snapshots.
+const progressInterval = time.Second
+
+// Snapshot is the progress of a running task.
+// The rolling figures cover the transactions completed since the previous snapshot.
+type Snapshot struct {
+	// Elapsed is the time since the start of the task.
+	Elapsed time.Duration
+	// Remaining is the estimated time until the end of the task,
+	// it is 0 if the task runs until interrupted.
+	Remaining time.Duration
+	// Transactions is the number of completed transactions.
+	Transactions int64
+	// Errors is the number of completed transactions with at least one failed request.
+	Errors int64
+	// InFlight is the number of transactions in progress.
+	InFlight int64
+	// RPS is the rolling number of transactions completed per second.
+	RPS float64
+	// P50 is the rolling median latency of the transactions.
+	P50 time.Duration
+	// P99 is the rolling 99th percentile latency of the transactions.
+	P99 time.Duration
+	// ErrorRate is the rolling fraction of the transactions with at least one failed request.
+	ErrorRate float64
+}
+
+// reportProgress calls OnProgress every progressInterval until done is closed.
+func (r *runner) reportProgress(done <-chan struct{}) {
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	last := r.start
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			r.OnProgress(r.snapshot(now.Sub(last)))
+			last = now
+		}
+	}
+}
+
+// snapshot collects the progress of the requesters, and restarts their rolling window.
+func (r *runner) snapshot(interval time.Duration) Snapshot {
+	snapshot := Snapshot{
+		Elapsed:  time.Now().Sub(r.start),
+		InFlight: atomic.LoadInt64(&r.inFlight),
+	}
+	r.mx.Lock()
+	workers := append([]*stats(nil), r.workers...)
+	r.mx.Unlock()
+	var window histogram
+	var windowErrCount int64
+	for _, w := range workers {
+		w.mx.Lock()
+		snapshot.Transactions += w.duration.count
+		snapshot.Errors += w.errCount
+		window.merge(&w.window)
+		windowErrCount += w.windowErrCount
+		w.window.reset()
+		w.windowErrCount = 0
+		w.mx.Unlock()
+	}
+	if interval > 0 {
+		snapshot.RPS = float64(window.count) / interval.Seconds()
+	}
+	if window.count > 0 {
+		snapshot.P50 = window.quantile(0.5)
+		snapshot.P99 = window.quantile(0.99)
+		snapshot.ErrorRate = float64(windowErrCount) / float64(window.count)
+	}
+	snapshot.Remaining = r.remaining(snapshot.Elapsed, snapshot.Transactions)
+	return snapshot
+}
+
+// remaining estimates the time until the end of the task, from the
+// configured duration or from the throughput so far.
+func (t *Task) remaining(elapsed time.Duration, done int64) time.Duration {
+	var remaining time.Duration
+	switch {
+	case len(t.Stages) > 0:
+		for _, stage := range t.Stages {
+			remaining += stage.Duration
+		}
+		remaining -= elapsed
+	case t.Duration > 0:
+		remaining = t.Duration - elapsed
+	case t.Number > 0 && done > 0:
+		remaining = time.Duration(float64(elapsed) * float64(int64(t.Number)-done) / float64(done))
+	}
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
@@ -1,12 +1,16 @@
 package stress
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,20 +18,51 @@ const (
 	barChar = "="
 )
 
+// Report formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// defaultPercentiles is the latency percentiles reported if Task.Percentiles is empty.
+var defaultPercentiles = []float64{50, 90, 95, 99, 99.9}
+
 type (
 	// Result is task result.
 	Result struct {
-		// Details is request details.
+		// Details is request details, in the order the requests were sent
+		// and skipped, one for each request unless a request has control flow.
 		Details []*ResultDetail
 		// Duration is the total duration of multiple requests in a transactional request.
 		Duration time.Duration
+		// CorrectedDuration is the duration from the scheduled start of the transaction,
+		// it is only set when the transactions are started at a rate.
+		// Unlike Duration, it includes the time the transaction waited to be sent.
+		CorrectedDuration time.Duration
+		// Stage is the index of the task stage in which the transaction started.
+		Stage int
+		// Scenario is the index of the scenario of the transaction in the mix.
+		Scenario int
+		// Aborted is whether the transaction was aborted before its end.
+		Aborted bool
+		// AbortReason is the reason the transaction was aborted, such as AbortFailure.
+		AbortReason string
+		// Start is the time the transaction started.
+		Start time.Time
+
+		// step is the index of the first request of the scenario in the requests of the task.
+		step int
 	}
 	// ResultDetail is request result details.
 	ResultDetail struct {
+		// Step is the index of the request in the requests of the transaction.
+		Step int
 		// URLStr is the request of URL.
 		URLStr string
 		// Method is the request of method.
 		Method string
+		// Start is the time the request started.
+		Start time.Time
 		// Err is the error message in the request.
 		Err error
 		// StatusCode is the status code for the response.
@@ -50,264 +85,737 @@ type (
 		ResAfterDuration time.Duration
 		// ContentLength is response content length.
 		ContentLength int64
+		// Checks is whether each check of the request passed, in the order
+		// of RequestConfig.Checks, it is nil if the request got no response.
+		Checks []bool
+		// Skipped is whether the request was skipped by an event, it was not sent.
+		Skipped bool
 	}
-	report struct {
-		total          time.Duration
-		reqBeforeTotal time.Duration
-		resAfterTotal  time.Duration
-		avgTotal       float64
-		fastest        float64
-		slowest        float64
-		average        float64
-		rps            float64
+	// Report is the summary of a task, durations are in seconds.
+	Report struct {
+		// Config is the effective configuration of the task.
+		Config *ReportConfig `json:"config"`
+		// Total is the duration of the task, without think time.
+		Total float64 `json:"total"`
+		// ReqBeforeTotal is the total duration of the functions before the requests.
+		ReqBeforeTotal float64 `json:"reqBeforeTotal"`
+		// ResAfterTotal is the total duration of the functions after the responses.
+		ResAfterTotal float64 `json:"resAfterTotal"`
+		// Transactions is the number of transactions.
+		Transactions int64 `json:"transactions"`
+		// Errors is the number of transactions with at least one failed request,
+		// that got no response or failed a check.
+		Errors int64 `json:"errors"`
+		// AbortedTransactions is the number of transactions aborted before their end, by a
+		// failed request, a jump to an unknown step or the end of the task. They are
+		// counted in Transactions as well, and in Errors if a request failed.
+		AbortedTransactions int64 `json:"abortedTransactions"`
+		// AbortedLatency is the distribution of the durations of the aborted transactions.
+		AbortedLatency *Latency `json:"abortedLatency,omitempty"`
+		// RPS is the number of transactions per second.
+		RPS float64 `json:"rps"`
+		// Latency is the distribution of the transaction durations.
+		Latency *Latency `json:"latency"`
+		// CorrectedLatency is the distribution of the transaction durations
+		// corrected for coordinated omission, it is only set in rate mode.
+		CorrectedLatency *Latency `json:"correctedLatency,omitempty"`
+		// Histogram is the histogram of the transaction durations.
+		Histogram []HistogramBucket `json:"histogram"`
+		// Timeline is the summary of the transactions started in each interval of the task.
+		Timeline []*TimelinePoint `json:"timeline"`
+		// Stages is the summary of each stage of the task.
+		Stages []*StageReport `json:"stages,omitempty"`
+		// Scenarios is the summary of each scenario of the mix.
+		Scenarios []*ScenarioReport `json:"scenarios,omitempty"`
+		// Steps is the summary of each request of the transaction.
+		Steps []*StepReport `json:"steps"`
+		// Thresholds is the result of the thresholds of the task and of its requests.
+		Thresholds []*ThresholdReport `json:"thresholds,omitempty"`
+		// Aborted is the reason the task was aborted before its end, if it was.
+		Aborted string `json:"aborted,omitempty"`
+		// MissedStarts is the number of transactions scheduled at the Rate or by the
+		// Stages that were not started before the end of the task, because Concurrent
+		// transactions were in progress.
+		MissedStarts int64 `json:"missedStarts,omitempty"`
 
-		results    []*Result
-		lats       []float64
-		details    []*detail
-		writers    []io.Writer
-		csvWriters []io.Writer
-		output     string
-	}
-	detail struct {
-		url            string
-		method         string
-		avgConn        float64
-		avgDNS         float64
-		avgReqBefore   float64
-		avgReq         float64
-		avgResAfter    float64
-		avgRes         float64
-		avgDelay       float64
-		connLats       []float64
-		dnsLats        []float64
-		reqBeforeLats  []float64
-		reqLats        []float64
-		resAfterLats   []float64
-		resLats        []float64
-		delayLats      []float64
-		statusCodeDist map[int]int
-		errorDist      map[string]int
-		sizeTotal      int64
+		// The latency at each of curvePercentiles, for the charts.
+		curve          []Percentile
+		correctedCurve []Percentile
+	}
+	// ReportConfig is the effective configuration of a task.
+	ReportConfig struct {
+		Number      int              `json:"number"`
+		Concurrent  int              `json:"concurrent"`
+		Duration    float64          `json:"duration"`
+		Rate        int              `json:"rate"`
+		Interval    float64          `json:"interval"`
+		Percentiles []float64        `json:"percentiles"`
+		Stages      []*ReportStage   `json:"stages,omitempty"`
+		Thresholds  []string         `json:"thresholds,omitempty"`
+		Abort       *ReportAbort     `json:"abort,omitempty"`
+		Cookies     string           `json:"cookies,omitempty"`
+		Requests    []*ReportRequest `json:"requests"`
+	}
+	// ReportStage is a stage of the task, the duration in seconds.
+	ReportStage struct {
+		Duration   float64 `json:"duration"`
+		Concurrent int     `json:"concurrent"`
+		Rate       int     `json:"rate,omitempty"`
+	}
+	// ReportAbort is the effective abort limits of the task, the durations in seconds.
+	ReportAbort struct {
+		Window              float64 `json:"window"`
+		MinTransactions     int     `json:"minTransactions"`
+		ErrorRate           float64 `json:"errorRate,omitempty"`
+		P99                 float64 `json:"p99,omitempty"`
+		ConsecutiveFailures int     `json:"consecutiveFailures,omitempty"`
+	}
+	// ReportRequest is the effective configuration of a request.
+	ReportRequest struct {
+		URL                string   `json:"url"`
+		Method             string   `json:"method"`
+		Timeout            int      `json:"timeout"`
+		ThinkTime          int      `json:"thinkTime"`
+		ProxyAddr          string   `json:"proxyAddr,omitempty"`
+		Host               string   `json:"host,omitempty"`
+		H2                 bool     `json:"h2"`
+		DisableCompression bool     `json:"disableCompression"`
+		DisableKeepAlives  bool     `json:"disableKeepAlives"`
+		DisableRedirects   bool     `json:"disableRedirects"`
+		Thresholds         []string `json:"thresholds,omitempty"`
+	}
+	// Latency is the distribution of durations.
+	Latency struct {
+		Count       int64        `json:"count"`
+		Average     float64      `json:"average"`
+		Fastest     float64      `json:"fastest"`
+		Slowest     float64      `json:"slowest"`
+		Percentiles []Percentile `json:"percentiles"`
+	}
+	// Percentile is the duration at or below which Percentile percent of the durations are.
+	Percentile struct {
+		Percentile float64 `json:"percentile"`
+		Value      float64 `json:"value"`
+	}
+	// HistogramBucket is the number of durations between the previous mark and Mark.
+	HistogramBucket struct {
+		Mark  float64 `json:"mark"`
+		Count int64   `json:"count"`
+	}
+	// TimelinePoint is the summary of the transactions started in an interval of the task.
+	TimelinePoint struct {
+		// Start is the offset of the interval from the start of the task.
+		Start        float64         `json:"start"`
+		Transactions int64           `json:"transactions"`
+		Errors       int             `json:"errors"`
+		RPS          float64         `json:"rps"`
+		Latency      *Latency        `json:"latency"`
+		Steps        []*TimelineStep `json:"steps"`
+	}
+	// TimelineStep is the summary of the requests of a step started in an interval,
+	// the latency only covers the requests with a response.
+	TimelineStep struct {
+		Requests int64    `json:"requests"`
+		Errors   int      `json:"errors"`
+		RPS      float64  `json:"rps"`
+		Latency  *Latency `json:"latency"`
+	}
+	// StageReport is the summary of the transactions started in a stage.
+	StageReport struct {
+		Start        float64 `json:"start"`
+		End          float64 `json:"end"`
+		Target       int     `json:"target"`
+		Transactions int     `json:"transactions"`
+		Errors       int     `json:"errors"`
+		Average      float64 `json:"average"`
+		RPS          float64 `json:"rps"`
+	}
+	// ScenarioReport is the summary of the transactions of a scenario of the mix.
+	ScenarioReport struct {
+		Name         string   `json:"name"`
+		Weight       int      `json:"weight"`
+		Transactions int64    `json:"transactions"`
+		Errors       int64    `json:"errors"`
+		RPS          float64  `json:"rps"`
+		Latency      *Latency `json:"latency"`
+	}
+	// StepReport is the summary of a request of the transaction,
+	// the phase latencies only count the requests with a response.
+	StepReport struct {
+		// Scenario is the name of the scenario of the request in the mix.
+		Scenario       string         `json:"scenario,omitempty"`
+		URL            string         `json:"url"`
+		Method         string         `json:"method"`
+		ResponseTime   *Latency       `json:"responseTime"`
+		DNSDialup      *Latency       `json:"dnsDialup"`
+		DNSLookup      *Latency       `json:"dnsLookup"`
+		RequestBefore  *Latency       `json:"requestBefore"`
+		RequestWrite   *Latency       `json:"requestWrite"`
+		ResponseWait   *Latency       `json:"responseWait"`
+		ResponseAfter  *Latency       `json:"responseAfter"`
+		ResponseRead   *Latency       `json:"responseRead"`
+		TotalData      int64          `json:"totalData"`
+		SizePerRequest int64          `json:"sizePerRequest"`
+		StatusCodes    map[int]int    `json:"statusCodes"`
+		Errors         map[string]int `json:"errors"`
+		// CheckFailures is the number of requests with a response that failed a check.
+		CheckFailures int64          `json:"checkFailures"`
+		Checks        []*CheckReport `json:"checks,omitempty"`
+		// Skipped is the number of requests skipped by an event or by their Skip.
+		Skipped int64 `json:"skipped,omitempty"`
+		// Aborts is the number of transactions aborted at the request.
+		Aborts int64 `json:"aborts,omitempty"`
+		// AbortReasons is the number of transactions aborted at the request by reason.
+		AbortReasons map[string]int `json:"abortReasons,omitempty"`
+	}
+	// ThresholdReport is the result of a threshold, Value is the measured value,
+	// in seconds for the latencies and in percent for an error rate.
+	ThresholdReport struct {
+		Threshold string `json:"threshold"`
+		// Step is the number of the request, starting at 1, or 0 for the task.
+		Step   int     `json:"step,omitempty"`
+		Value  float64 `json:"value"`
+		Passed bool    `json:"passed"`
+		// NoData is whether a latency threshold failed without latency to evaluate.
+		NoData bool `json:"noData,omitempty"`
+	}
+	// CheckReport is the number of responses that passed and failed a check.
+	CheckReport struct {
+		Name   string `json:"name"`
+		Passed int64  `json:"passed"`
+		Failed int64  `json:"failed"`
+	}
+	// printer writes a report to stdout and to the output directory.
+	printer struct {
+		writers []io.Writer
+	}
+	// csvWriter streams the details of the successful requests to a CSV file.
+	csvWriter struct {
+		mx   sync.Mutex
+		file *os.File
+		w    *bufio.Writer
 	}
 )
 
-func newReport(results []*Result, output string, total time.Duration) *report {
-	return &report{
-		output:  output,
-		results: results,
-		total:   total,
+func newReport(rn *runner, s *stats, total time.Duration) *Report {
+	percentiles := rn.Percentiles
+	if len(percentiles) == 0 {
+		percentiles = defaultPercentiles
+	}
+	r := &Report{
+		Config:         newReportConfig(rn, percentiles),
+		Total:          total.Seconds(),
+		ReqBeforeTotal: s.reqBeforeTotal.Seconds(),
+		ResAfterTotal:  s.resAfterTotal.Seconds(),
+		Transactions:   s.duration.count,
+		Errors:         s.errCount,
+		RPS:            float64(s.duration.count) / total.Seconds(),
+		Latency:        newLatency(&s.duration, percentiles),
+		Histogram:      newHistogramBuckets(&s.duration),
+	}
+	rn.mx.Lock()
+	r.Aborted = rn.aborted
+	rn.mx.Unlock()
+	r.MissedStarts = atomic.LoadInt64(&rn.missed)
+	r.curve = newLatency(&s.duration, curvePercentiles).Percentiles
+	if s.aborted.count > 0 {
+		r.AbortedTransactions = s.aborted.count
+		r.AbortedLatency = newLatency(&s.aborted, percentiles)
+	}
+	if s.corrected.count > 0 {
+		r.CorrectedLatency = newLatency(&s.corrected, percentiles)
+		r.correctedCurve = newLatency(&s.corrected, curvePercentiles).Percentiles
+	}
+	if s.timeline != nil {
+		r.Timeline = newTimelinePoints(s.timeline, len(s.steps), percentiles)
+	}
+	rate := stagedRate(rn.Stages)
+	var start time.Duration
+	for i, stage := range rn.Stages {
+		sr := &StageReport{
+			Start:        start.Seconds(),
+			End:          (start + stage.Duration).Seconds(),
+			Target:       stage.target(rate),
+			Transactions: s.stages[i].count,
+			Errors:       s.stages[i].errCount,
+		}
+		if sr.Transactions > 0 {
+			sr.Average = s.stages[i].duration.Seconds() / float64(sr.Transactions)
+		}
+		if stage.Duration > 0 {
+			sr.RPS = float64(sr.Transactions) / stage.Duration.Seconds()
+		}
+		r.Stages = append(r.Stages, sr)
+		start += stage.Duration
+	}
+	for i, scenario := range s.scenarios {
+		r.Scenarios = append(r.Scenarios, &ScenarioReport{
+			Name:         rn.mix.scenarios[i].Name,
+			Weight:       rn.mix.weight(i),
+			Transactions: scenario.duration.count,
+			Errors:       scenario.errCount,
+			RPS:          float64(scenario.duration.count) / total.Seconds(),
+			Latency:      newLatency(&scenario.duration, percentiles),
+		})
+	}
+	for i, step := range s.steps {
+		// The configured URL, a templated URL differs for each request.
+		sr := &StepReport{
+			URL:           rn.reqConfigs[i].URLStr,
+			Method:        rn.reqConfigs[i].Method,
+			ResponseTime:  newLatency(&step.duration, percentiles),
+			DNSDialup:     newLatency(&step.conn, percentiles),
+			DNSLookup:     newLatency(&step.dns, percentiles),
+			RequestBefore: newLatency(&step.reqBefore, percentiles),
+			RequestWrite:  newLatency(&step.req, percentiles),
+			ResponseWait:  newLatency(&step.delay, percentiles),
+			ResponseAfter: newLatency(&step.resAfter, percentiles),
+			ResponseRead:  newLatency(&step.res, percentiles),
+			TotalData:     step.sizeTotal,
+			StatusCodes:   step.statusCodeDist,
+			Errors:        step.errorDist,
+			CheckFailures: step.checkFailures,
+			Skipped:       step.skipped,
+			Aborts:        step.aborts,
+			AbortReasons:  step.abortReasons,
+		}
+		if rn.mix != nil {
+			sr.Scenario = rn.mix.scenarios[rn.mix.scenario(i)].Name
+		}
+		for j, c := range rn.reqConfigs[i].Checks {
+			cr := &CheckReport{Name: c.Name}
+			if j < len(step.checkPassed) {
+				cr.Passed = step.checkPassed[j]
+				cr.Failed = step.checkFailed[j]
+			}
+			sr.Checks = append(sr.Checks, cr)
+		}
+		if step.res.count > 0 {
+			sr.SizePerRequest = step.sizeTotal / step.res.count
+		}
+		r.Steps = append(r.Steps, sr)
+	}
+	for _, th := range rn.thresholds {
+		r.Thresholds = append(r.Thresholds, th.evaluate(&s.duration, s.duration.count, s.errCount, r.Total))
 	}
+	for i, step := range s.steps {
+		var transportErrors int64
+		for _, n := range step.errorDist {
+			transportErrors += int64(n)
+		}
+		for _, th := range rn.reqConfigs[i].thresholds {
+			tr := th.evaluate(&step.duration, step.duration.count+transportErrors, transportErrors+step.checkFailures, r.Total)
+			tr.Step = i + 1
+			r.Thresholds = append(r.Thresholds, tr)
+		}
+	}
+	return r
 }
 
-func (r *report) finalize() {
-	for _, result := range r.results {
-		r.lats = append(r.lats, result.Duration.Seconds())
-		r.avgTotal += result.Duration.Seconds()
-		if r.details == nil {
-			r.details = make([]*detail, len(result.Details))
-		}
-		for i, res := range result.Details {
-			if r.details[i] == nil {
-				r.details[i] = &detail{
-					statusCodeDist: make(map[int]int),
-					errorDist:      make(map[string]int),
-				}
+// Passed reports whether all the thresholds passed.
+func (r *Report) Passed() bool {
+	for _, th := range r.Thresholds {
+		if !th.Passed {
+			return false
+		}
+	}
+	return true
+}
 
-			}
-			r.details[i].url = res.URLStr
-			r.details[i].method = res.Method
-			if res.Err != nil {
-				r.details[i].errorDist[res.Err.Error()]++
-			} else {
-				r.reqBeforeTotal += res.ReqBeforeDuration
-				r.resAfterTotal += res.ResAfterDuration
-				r.details[i].avgConn += res.ConnDuration.Seconds()
-				r.details[i].avgDelay += res.DelayDuration.Seconds()
-				r.details[i].avgDNS += res.DNSDuration.Seconds()
-				r.details[i].avgReqBefore += res.ReqBeforeDuration.Seconds()
-				r.details[i].avgReq += res.ReqDuration.Seconds()
-				r.details[i].avgResAfter += res.ResAfterDuration.Seconds()
-				r.details[i].avgRes += res.ResDuration.Seconds()
-				r.details[i].connLats = append(r.details[i].connLats, res.ConnDuration.Seconds())
-				r.details[i].dnsLats = append(r.details[i].dnsLats, res.DNSDuration.Seconds())
-				r.details[i].reqBeforeLats = append(r.details[i].reqBeforeLats, res.ReqBeforeDuration.Seconds())
-				r.details[i].reqLats = append(r.details[i].reqLats, res.ReqDuration.Seconds())
-				r.details[i].delayLats = append(r.details[i].delayLats, res.DelayDuration.Seconds())
-				r.details[i].resAfterLats = append(r.details[i].resAfterLats, res.ResAfterDuration.Seconds())
-				r.details[i].resLats = append(r.details[i].resLats, res.ResDuration.Seconds())
-				r.details[i].statusCodeDist[res.StatusCode]++
-				if res.ContentLength > 0 {
-					r.details[i].sizeTotal += res.ContentLength
-				}
-			}
+func newReportConfig(rn *runner, percentiles []float64) *ReportConfig {
+	c := &ReportConfig{
+		Number:      rn.Number,
+		Concurrent:  rn.Concurrent,
+		Duration:    rn.Duration.Seconds(),
+		Rate:        rn.Rate,
+		Percentiles: percentiles,
+		Thresholds:  rn.Thresholds,
+		Cookies:     rn.Cookies,
+	}
+	if rn.timeline != nil {
+		c.Interval = rn.timeline.interval.Seconds()
+	}
+	for _, stage := range rn.Stages {
+		c.Stages = append(c.Stages, &ReportStage{
+			Duration:   stage.Duration.Seconds(),
+			Concurrent: stage.Concurrent,
+			Rate:       stage.Rate,
+		})
+	}
+	if a := rn.Abort; a != nil {
+		c.Abort = &ReportAbort{
+			Window:              a.Window.Seconds(),
+			MinTransactions:     a.MinTransactions,
+			ErrorRate:           a.ErrorRate,
+			P99:                 a.P99.Seconds(),
+			ConsecutiveFailures: a.ConsecutiveFailures,
+		}
+		if a.Window == 0 {
+			c.Abort.Window = defaultAbortWindow.Seconds()
+		}
+		if a.MinTransactions == 0 {
+			c.Abort.MinTransactions = defaultAbortMinTransactions
 		}
 	}
-	r.rps = float64(len(r.lats)) / r.total.Seconds()
-	r.average = r.avgTotal / float64(len(r.lats))
-	for i, n := 0, len(r.details); i < n; i++ {
-		r.details[i].avgConn = r.details[i].avgConn / float64(len(r.lats))
-		r.details[i].avgDelay = r.details[i].avgDelay / float64(len(r.lats))
-		r.details[i].avgDNS = r.details[i].avgDNS / float64(len(r.lats))
-		r.details[i].avgReqBefore = r.details[i].avgReqBefore / float64(len(r.lats))
-		r.details[i].avgReq = r.details[i].avgReq / float64(len(r.lats))
-		r.details[i].avgResAfter = r.details[i].avgResAfter / float64(len(r.lats))
-		r.details[i].avgRes = r.details[i].avgRes / float64(len(r.lats))
+	for _, config := range rn.reqConfigs {
+		req := &ReportRequest{
+			URL:                config.URLStr,
+			Method:             config.Method,
+			Timeout:            config.Timeout,
+			ThinkTime:          config.ThinkTime,
+			Host:               config.Host,
+			H2:                 config.H2,
+			DisableCompression: config.DisableCompression,
+			DisableKeepAlives:  config.DisableKeepAlives,
+			DisableRedirects:   config.DisableRedirects,
+			Thresholds:         config.Thresholds,
+		}
+		if config.ProxyAddr != nil {
+			req.ProxyAddr = config.ProxyAddr.String()
+		}
+		c.Requests = append(c.Requests, req)
 	}
-	r.print()
+	return c
 }
 
-func (r *report) print() {
-	r.setWriter()
-	if r.output != "" {
-		r.printCSV(r.csvWriters...)
+func newLatency(h *histogram, percentiles []float64) *Latency {
+	l := &Latency{
+		Count:   h.count,
+		Average: h.mean().Seconds(),
+		Fastest: h.min.Seconds(),
+		Slowest: h.max.Seconds(),
 	}
+	for _, p := range percentiles {
+		l.Percentiles = append(l.Percentiles, Percentile{
+			Percentile: p,
+			Value:      h.quantile(p / 100).Seconds(),
+		})
+	}
+	return l
+}
 
-	if len(r.lats) > 0 {
-		sort.Float64s(r.lats)
-		r.fastest = r.lats[0]
-		r.slowest = r.lats[len(r.lats)-1]
-		r.printf("\nSummary:\n")
-		r.printf("  Total:\t\t%4.4f secs\n", r.total.Seconds())
-		r.printf("  ReqBeforeTotal:\t%4.4f secs\n", r.reqBeforeTotal.Seconds())
-		r.printf("  ResAfterTotal:\t%4.4f secs\n", r.resAfterTotal.Seconds())
-		r.printf("  Slowest:\t\t%4.4f secs\n", r.slowest)
-		r.printf("  Fastest:\t\t%4.4f secs\n", r.fastest)
-		r.printf("  Average:\t\t%4.4f secs\n", r.average)
-		r.printf("  Requests/sec:\t\t%4.4f\n", r.rps)
-		r.printf("\nDetailed Report:\n")
-		for _, detail := range r.details {
-			r.printf("\n  URL:  [%s] %s\n", detail.method, detail.url)
-			if len(detail.resLats) > 0 {
-				r.printSection("DNS+dialup", detail.avgConn, detail.connLats)
-				r.printSection("DNS-lookup", detail.avgDNS, detail.dnsLats)
-				r.printSection("Request Before", detail.avgReqBefore, detail.reqBeforeLats)
-				r.printSection("Request Write", detail.avgReq, detail.reqLats)
-				r.printSection("Response Wait", detail.avgDelay, detail.delayLats)
-				r.printSection("Response After", detail.avgResAfter, detail.resAfterLats)
-				r.printSection("Response Read", detail.avgRes, detail.resLats)
-				if detail.sizeTotal > 0 {
-					r.printf("\n\tResponse Summary:\n")
-					r.printf("\t\tTotal data:\t%d bytes\n", detail.sizeTotal)
-					r.printf("\t\tSize/request:\t%d bytes\n", detail.sizeTotal/int64(len(r.lats)))
-				}
-				r.printStatusCodes(detail.statusCodeDist)
-			}
-			if len(detail.errorDist) > 0 {
-				r.printErrors(detail.errorDist)
-			}
-		}
-		r.printHistogram()
+// newHistogramBuckets splits the durations between the fastest and the slowest in 10 buckets.
+func newHistogramBuckets(h *histogram) []HistogramBucket {
+	if h.count == 0 {
+		return nil
 	}
+	bc := 10
+	buckets := make([]HistogramBucket, bc+1)
+	fastest, slowest := h.min.Seconds(), h.max.Seconds()
+	bs := (slowest - fastest) / float64(bc)
+	for i := 0; i < bc; i++ {
+		buckets[i].Mark = fastest + bs*float64(i)
+	}
+	buckets[bc].Mark = slowest
+	var bi int
+	h.each(func(d time.Duration, count int64) {
+		for bi < len(buckets)-1 && d.Seconds() > buckets[bi].Mark {
+			bi++
+		}
+		buckets[bi].Count += count
+	})
+	return buckets
 }
 
-func (r *report) setWriter() {
-	r.writers = append(r.writers, os.Stdout)
-	if r.output != "" {
-		fpath := filepath.Join(r.output, "report.txt")
-		file, err := os.Create(fpath)
-		if err == nil {
-			r.writers = append(r.writers, file)
-		}
-		for _, detail := range r.details {
-			_, f := filepath.Split(strings.Replace(strings.Replace(detail.url, ".", "_", -1), ":", "_", -1))
-			fpath := filepath.Join(r.output, f)
-			file, err := os.Create(fmt.Sprintf("%s.csv", fpath))
-			if err == nil {
-				r.csvWriters = append(r.csvWriters, file)
-			}
+// print writes the report in format to stdout, and to the output directory if set.
+func (r *Report) print(format, output string) {
+	p := &printer{writers: []io.Writer{os.Stdout}}
+	if output != "" {
+		if err := r.writeHTML(output); err != nil {
+			fmt.Fprintf(os.Stderr, "could not write the HTML report: %v\n", err)
+		}
+		if err := r.writeTimeSeries(format, output); err != nil {
+			fmt.Fprintf(os.Stderr, "could not write the time series: %v\n", err)
 		}
+		name := "report.txt"
+		if format == FormatJSON {
+			name = "report.json"
+		}
+		file, err := os.Create(filepath.Join(output, name))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not write the report: %v\n", err)
+		} else {
+			defer file.Close()
+			p.writers = append(p.writers, file)
+		}
+	}
+	if format == FormatJSON {
+		p.printJSON(r)
+	} else {
+		p.printText(r)
 	}
 }
 
-func (r *report) printCSV(writers ...io.Writer) {
-	for _, writer := range writers {
-		fmt.Fprintf(writer, "response-time,DNS+dialup,DNS,Request-before,Request-write,Response-delay,Response-after,Response-read\n")
-		for _, detail := range r.details {
-			for i, val := range detail.reqLats {
-				fmt.Fprintf(writer, "%4.4f,%4.4f,%4.4f,%4.4f,%4.4f,%4.4f,%4.4f,%4.4f\n",
-					val, detail.connLats[i], detail.dnsLats[i], detail.reqBeforeLats[i], detail.reqLats[i], detail.delayLats[i], detail.resAfterLats[i], detail.resLats[i])
+func (p *printer) printJSON(r *Report) {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return
+	}
+	p.printf("%s\n", b)
+}
+
+func (p *printer) printText(r *Report) {
+	if r.Transactions == 0 {
+		return
+	}
+	p.printf("\nSummary:\n")
+	p.printf("  Total:\t\t%4.4f secs\n", r.Total)
+	p.printf("  ReqBeforeTotal:\t%4.4f secs\n", r.ReqBeforeTotal)
+	p.printf("  ResAfterTotal:\t%4.4f secs\n", r.ResAfterTotal)
+	p.printf("  Slowest:\t\t%4.4f secs\n", r.Latency.Slowest)
+	p.printf("  Fastest:\t\t%4.4f secs\n", r.Latency.Fastest)
+	p.printf("  Average:\t\t%4.4f secs\n", r.Latency.Average)
+	p.printf("  Requests/sec:\t\t%4.4f\n", r.RPS)
+	if r.Aborted != "" {
+		p.printf("  Task aborted:\t\t%s\n", r.Aborted)
+	}
+	if r.AbortedLatency != nil {
+		p.printf("\n  Aborted transactions:\n")
+		p.printf("  Completed:\t\t%d\n", r.Transactions-r.AbortedTransactions)
+		p.printf("  Aborted:\t\t%d\n", r.AbortedTransactions)
+		p.printf("  Average:\t\t%4.4f secs\n", r.AbortedLatency.Average)
+	}
+	if r.MissedStarts > 0 {
+		p.printf("  Missed starts:\t\t%d\n", r.MissedStarts)
+	}
+	if r.CorrectedLatency != nil {
+		p.printf("\n  Corrected for coordinated omission:\n")
+		p.printf("  Slowest:\t\t%4.4f secs\n", r.CorrectedLatency.Slowest)
+		p.printf("  Average:\t\t%4.4f secs\n", r.CorrectedLatency.Average)
+	}
+	p.printLatencies(r.Latency, r.CorrectedLatency)
+	if len(r.Thresholds) > 0 {
+		p.printThresholds(r.Thresholds)
+	}
+	if len(r.Stages) > 0 {
+		p.printStages(r.Stages)
+	}
+	if len(r.Scenarios) > 0 {
+		p.printScenarios(r.Scenarios)
+	}
+	p.printf("\nDetailed Report:\n")
+	for _, step := range r.Steps {
+		if step.Scenario != "" {
+			p.printf("\n  Scenario:  %s", step.Scenario)
+		}
+		p.printf("\n  URL:  [%s] %s\n", step.Method, step.URL)
+		if step.ResponseRead.Count > 0 {
+			p.printSection("Response Time", step.ResponseTime)
+			p.printSection("DNS+dialup", step.DNSDialup)
+			p.printSection("DNS-lookup", step.DNSLookup)
+			p.printSection("Request Before", step.RequestBefore)
+			p.printSection("Request Write", step.RequestWrite)
+			p.printSection("Response Wait", step.ResponseWait)
+			p.printSection("Response After", step.ResponseAfter)
+			p.printSection("Response Read", step.ResponseRead)
+			if step.TotalData > 0 {
+				p.printf("\n\tResponse Summary:\n")
+				p.printf("\t\tTotal data:\t%d bytes\n", step.TotalData)
+				p.printf("\t\tSize/request:\t%d bytes\n", step.SizePerRequest)
+			}
+			p.printStatusCodes(step.StatusCodes)
+		}
+		if len(step.Checks) > 0 {
+			p.printChecks(step.Checks)
+		}
+		if len(step.Errors) > 0 {
+			p.printErrors(step.Errors)
+		}
+		if step.Skipped > 0 {
+			p.printf("\n\tSkipped:\t%d requests\n", step.Skipped)
+		}
+		if step.Aborts > 0 {
+			p.printf("\n\tAborted:\t%d transactions\n", step.Aborts)
+			for reason, num := range step.AbortReasons {
+				p.printf("\t\t[%d]\t%s\n", num, reason)
 			}
 		}
 	}
+	p.printHistogram(r.Histogram)
 }
 
-func (r *report) printSection(tag string, avg float64, lats []float64) {
-	sort.Float64s(lats)
-	fastest, slowest := lats[0], lats[len(lats)-1]
-	r.printf("\n\t%s:\n", tag)
-	r.printf("  \t\tAverage:\t%4.4f secs\n", avg)
-	r.printf("  \t\tFastest:\t%4.4f secs\n", fastest)
-	r.printf("  \t\tSlowest:\t%4.4f secs\n", slowest)
+func (p *printer) printSection(tag string, l *Latency) {
+	p.printf("\n\t%s:\n", tag)
+	p.printf("  \t\tAverage:\t%4.4f secs\n", l.Average)
+	p.printf("  \t\tFastest:\t%4.4f secs\n", l.Fastest)
+	p.printf("  \t\tSlowest:\t%4.4f secs\n", l.Slowest)
+	for _, pctl := range l.Percentiles {
+		p.printf("  \t\t%s:\t\t%4.4f secs\n", percentileTag(pctl.Percentile), pctl.Value)
+	}
 }
 
-func (r *report) printLatencies() {
-	pctls := []int{10, 25, 50, 75, 90, 95, 99}
-	data := make([]float64, len(pctls))
-	j := 0
-	for i := 0; i < len(r.lats) && j < len(pctls); i++ {
-		current := i * 100 / len(r.lats)
-		if current >= pctls[j] {
-			data[j] = r.lats[i]
-			j++
+func (p *printer) printLatencies(l, corrected *Latency) {
+	if corrected == nil {
+		p.printf("\nLatency distribution:\n")
+		for _, pctl := range l.Percentiles {
+			p.printf("  %s in %4.4f secs\n", percentileTag(pctl.Percentile), pctl.Value)
 		}
+		return
 	}
-	r.printf("\nLatency distribution:\n")
-	for i := 0; i < len(pctls); i++ {
-		if data[i] > 0 {
-			r.printf("  %v%% in %4.4f secs\n", pctls[i], data[i])
-		}
+	p.printf("\nLatency distribution:\tuncorrected\tcorrected\n")
+	for i, pctl := range l.Percentiles {
+		p.printf("  %s in\t\t%4.4f secs\t%4.4f secs\n", percentileTag(pctl.Percentile), pctl.Value, corrected.Percentiles[i].Value)
 	}
 }
 
-func (r *report) printHistogram() {
-	bc := 10
-	buckets := make([]float64, bc+1)
-	counts := make([]int, bc+1)
-	bs := (r.slowest - r.fastest) / float64(bc)
-	for i := 0; i < bc; i++ {
-		buckets[i] = r.fastest + bs*float64(i)
-	}
-	buckets[bc] = r.slowest
-	var bi int
-	var max int
-	for i := 0; i < len(r.lats); {
-		if r.lats[i] <= buckets[bi] {
-			i++
-			counts[bi]++
-			if max < counts[bi] {
-				max = counts[bi]
-			}
-		} else if bi < len(buckets)-1 {
-			bi++
+// percentileTag formats a percentile such as 99.9 as "99.9%".
+func percentileTag(p float64) string {
+	return strconv.FormatFloat(p, 'f', -1, 64) + "%"
+}
+
+func (p *printer) printHistogram(buckets []HistogramBucket) {
+	var max int64
+	for _, bucket := range buckets {
+		if max < bucket.Count {
+			max = bucket.Count
 		}
 	}
-	r.printf("\nResponse time histogram:\n")
-	for i := 0; i < len(buckets); i++ {
+	p.printf("\nResponse time histogram:\n")
+	for _, bucket := range buckets {
 		// Normalize bar lengths.
-		var barLen int
+		var barLen int64
 		if max > 0 {
-			barLen = (counts[i]*40 + max/2) / max
+			barLen = (bucket.Count*40 + max/2) / max
 		}
-		r.printf("  %4.3f [%v]\t|%v\n", buckets[i], counts[i], strings.Repeat(barChar, barLen))
+		p.printf("  %4.3f [%v]\t|%v\n", bucket.Mark, bucket.Count, strings.Repeat(barChar, int(barLen)))
 	}
 }
 
-func (r *report) printStatusCodes(statusCodeDist map[int]int) {
-	r.printf("\n\tStatus code distribution:\n")
+func (p *printer) printThresholds(thresholds []*ThresholdReport) {
+	p.printf("\nThresholds:\n")
+	for _, th := range thresholds {
+		result := "PASS"
+		if !th.Passed {
+			result = "FAIL"
+		}
+		step := "task"
+		if th.Step > 0 {
+			step = fmt.Sprintf("request %d", th.Step)
+		}
+		if th.NoData {
+			p.printf("  [%s]\t%s\t%s\tno data\n", result, step, th.Threshold)
+			continue
+		}
+		p.printf("  [%s]\t%s\t%s\t%4.4f\n", result, step, th.Threshold, th.Value)
+	}
+}
+
+func (p *printer) printStages(stages []*StageReport) {
+	p.printf("\nStages:\n")
+	for i, stage := range stages {
+		p.printf("  [%d] %4.4f - %4.4f secs\ttarget %d\n", i+1, stage.Start, stage.End, stage.Target)
+		p.printf("  \tTransactions:\t%d\n", stage.Transactions)
+		p.printf("  \tErrors:\t\t%d\n", stage.Errors)
+		p.printf("  \tAverage:\t%4.4f secs\n", stage.Average)
+		if stage.End > stage.Start {
+			p.printf("  \tRequests/sec:\t%4.4f\n", stage.RPS)
+		}
+	}
+}
+
+func (p *printer) printScenarios(scenarios []*ScenarioReport) {
+	p.printf("\nScenarios:\n")
+	for _, scenario := range scenarios {
+		p.printf("  %s\tweight %d\n", scenario.Name, scenario.Weight)
+		p.printf("  \tTransactions:\t%d\n", scenario.Transactions)
+		p.printf("  \tErrors:\t\t%d\n", scenario.Errors)
+		p.printf("  \tAverage:\t%4.4f secs\n", scenario.Latency.Average)
+		p.printf("  \tRequests/sec:\t%4.4f\n", scenario.RPS)
+	}
+}
+
+func (p *printer) printStatusCodes(statusCodeDist map[int]int) {
+	p.printf("\n\tStatus code distribution:\n")
 	for code, num := range statusCodeDist {
-		r.printf("\t\t[%d]\t%d responses\n", code, num)
+		p.printf("\t\t[%d]\t%d responses\n", code, num)
 	}
 }
 
-func (r *report) printErrors(errorDist map[string]int) {
-	r.printf("\n\tError distribution:\n")
+func (p *printer) printChecks(checks []*CheckReport) {
+	p.printf("\n\tChecks:\n")
+	for _, c := range checks {
+		p.printf("\t\t[%d passed, %d failed]\t%s\n", c.Passed, c.Failed, c.Name)
+	}
+}
+
+func (p *printer) printErrors(errorDist map[string]int) {
+	p.printf("\n\tError distribution:\n")
 	for err, num := range errorDist {
-		r.printf("\t\t[%d]\t%s\n", num, err)
+		p.printf("\t\t[%d]\t%s\n", num, err)
 	}
 }
 
-func (r *report) printf(s string, v ...interface{}) {
-	for _, writer := range r.writers {
+func (p *printer) printf(s string, v ...interface{}) {
+	for _, writer := range p.writers {
 		fmt.Fprintf(writer, s, v...)
 	}
 }
+
+// newCSVWriters creates a CSV file in output for each request config,
+// requests with the same URL share the same file.
+func newCSVWriters(output string, configs []*RequestConfig) ([]*csvWriter, error) {
+	writers := make([]*csvWriter, len(configs))
+	paths := make(map[string]*csvWriter)
+	for i, config := range configs {
+		_, f := filepath.Split(strings.Replace(strings.Replace(config.URLStr, ".", "_", -1), ":", "_", -1))
+		fpath := fmt.Sprintf("%s.csv", filepath.Join(output, f))
+		if paths[fpath] == nil {
+			file, err := os.Create(fpath)
+			if err != nil {
+				for _, w := range paths {
+					w.close()
+				}
+				return nil, err
+			}
+			w := bufio.NewWriter(file)
+			fmt.Fprintf(w, "response-time,DNS+dialup,DNS,Request-before,Request-write,Response-delay,Response-after,Response-read\n")
+			paths[fpath] = &csvWriter{file: file, w: w}
+		}
+		writers[i] = paths[fpath]
+	}
+	return writers, nil
+}
+
+// failed reports whether a request of the transaction failed.
+func (r *Result) failed() bool {
+	for _, res := range r.Details {
+		if res.Failed() {
+			return true
+		}
+	}
+	return false
+}
+
+// Failed reports whether the request got no response or failed a check.
+func (res *ResultDetail) Failed() bool {
+	if res.Err != nil {
+		return true
+	}
+	for _, passed := range res.Checks {
+		if !passed {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *csvWriter) write(res *ResultDetail) {
+	if res.Err != nil || res.Skipped {
+		return
+	}
+	c.mx.Lock()
+	fmt.Fprintf(c.w, "%4.4f,%4.4f,%4.4f,%4.4f,%4.4f,%4.4f,%4.4f,%4.4f\n",
+		res.Duration.Seconds(), res.ConnDuration.Seconds(), res.DNSDuration.Seconds(), res.ReqBeforeDuration.Seconds(),
+		res.ReqDuration.Seconds(), res.DelayDuration.Seconds(), res.ResAfterDuration.Seconds(), res.ResDuration.Seconds())
+	c.mx.Unlock()
+}
+
+func (c *csvWriter) close() {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.file != nil {
+		c.w.Flush()
+		c.file.Close()
+		c.file = nil
+	}
+}
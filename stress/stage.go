@@ -0,0 +1,163 @@
+package stress
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// stageTick is the interval at which the staged targets are re-evaluated.
+const stageTick = 100 * time.Millisecond
+
+// Stage is a stage of a multi-stage load profile.
+// Only one of Concurrent and Rate is used for all stages of a task.
+type Stage struct {
+	// Duration is the duration of the stage.
+	Duration time.Duration
+	// Concurrent is the number of concurrent requesters at the end of the stage.
+	Concurrent int
+	// Rate is the number of transactions to start per second at the end of the stage.
+	// If any stage sets Rate, Task.Concurrent caps the in-flight transactions.
+	Rate int
+}
+
+func (s Stage) target(rate bool) int {
+	if rate {
+		return s.Rate
+	}
+	return s.Concurrent
+}
+
+// stagedRate reports whether the stages target a rate instead of a concurrency.
+func stagedRate(stages []Stage) bool {
+	for _, stage := range stages {
+		if stage.Rate > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// stageAt returns the index of the stage running at offset after the start
+// of the task and its current target, the index is -1 once all stages are over.
+// The target ramps linearly from the target of the previous stage, starting at 0.
+func (t *Task) stageAt(offset time.Duration) (int, float64) {
+	rate := stagedRate(t.Stages)
+	var from float64
+	var end time.Duration
+	for i, stage := range t.Stages {
+		to := float64(stage.target(rate))
+		if offset < end+stage.Duration {
+			progress := float64(offset-end) / float64(stage.Duration)
+			return i, from + (to-from)*progress
+		}
+		end += stage.Duration
+		from = to
+	}
+	return -1, from
+}
+
+func (t *Task) checkStages() error {
+	if t.Number != 0 || t.Duration > 0 {
+		return errors.New("Stages cannot be used with Number or Duration")
+	}
+	if t.Rate > 0 {
+		return errors.New("Stages cannot be used with Rate")
+	}
+	var concurrent, rate bool
+	for _, stage := range t.Stages {
+		if stage.Duration < 0 || stage.Concurrent < 0 || stage.Rate < 0 {
+			return errors.New("Stage Duration, Concurrent and Rate cannot be smaller than 0")
+		}
+		concurrent = concurrent || stage.Concurrent > 0
+		rate = rate || stage.Rate > 0
+	}
+	if concurrent && rate {
+		return errors.New("Stages cannot mix Concurrent and Rate")
+	}
+	return nil
+}
+
+// runStagedRequesters starts and retires requesters to follow
+// the concurrency targets of the stages.
+func (r *runner) runStagedRequesters() {
+	var wg sync.WaitGroup
+	var stops []chan struct{}
+	ticker := time.NewTicker(stageTick)
+	defer ticker.Stop()
+	for {
+		stage, target := r.stageAt(time.Now().Sub(r.start))
+		if stage < 0 || r.isStopped() {
+			break
+		}
+		n := int(target + 0.5)
+		for len(stops) < n {
+			stop := make(chan struct{})
+			stops = append(stops, stop)
+			wg.Add(1)
+			go func(routineNum int) {
+				r.runStagedRequester(routineNum, stop)
+				wg.Done()
+			}(len(stops) - 1)
+		}
+		for len(stops) > n {
+			close(stops[len(stops)-1])
+			stops = stops[:len(stops)-1]
+		}
+		<-ticker.C
+	}
+	for _, stop := range stops {
+		close(stop)
+	}
+	wg.Wait()
+}
+
+// runStagedRequester sends requests until it is retired or all stages are over.
+func (r *runner) runStagedRequester(no int, stop chan struct{}) {
+	s := r.workerStats(no)
+	vu := r.startVU(no)
+	defer r.stopVU(vu)
+	for i := 0; ; i++ {
+		select {
+		case <-stop:
+			return
+		case <-r.stopped:
+			return
+		default:
+		}
+		stage, _ := r.stageAt(time.Now().Sub(r.start))
+		if stage < 0 {
+			return
+		}
+		r.record(s, r.sendRequest(vu, i, tick{stage: stage}))
+	}
+}
+
+// scheduleStages schedules transaction starts following the rate targets of the stages.
+// Starts are accumulated as credit over steps of at most stageTick, so that
+// low and changing rates are followed closely.
+func (r *runner) scheduleStages(ticks chan<- tick) {
+	var offset time.Duration
+	credit := 1.0
+	for {
+		stage, rate := r.stageAt(offset)
+		if stage < 0 {
+			return
+		}
+		if credit >= 1-1e-6 {
+			credit--
+			if !r.sendLateTick(ticks, r.tickAt(offset, stage)) {
+				return
+			}
+			continue
+		}
+		step := stageTick
+		if rate > 0 {
+			if need := time.Duration((1 - credit) / rate * float64(time.Second)); need > 0 && need < step {
+				step = need
+			}
+		}
+		credit += rate * step.Seconds()
+		offset += step
+	}
+}
@@ -0,0 +1,257 @@
+package stress
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	// stats is the aggregate of the results of a task, recorded as the results arrive.
+	// Each requester records into its own stats, they are merged for the report.
+	stats struct {
+		mx             sync.Mutex
+		duration       histogram
+		corrected      histogram
+		aborted        histogram
+		errCount       int64
+		reqBeforeTotal time.Duration
+		resAfterTotal  time.Duration
+		steps          []*stepStats
+		stages         []*stageStats
+		scenarios      []*scenarioStats
+
+		// The transactions started in the current interval, merged
+		// into the shared timeline when the next interval starts.
+		timeline  *timeline
+		open      *timeBucket
+		openIndex int
+
+		// The transactions recorded since the last progress snapshot,
+		// only recorded if rolling is set.
+		rolling        bool
+		window         histogram
+		windowErrCount int64
+	}
+	// stepStats is the aggregate of the results of a request of the transaction.
+	stepStats struct {
+		duration       histogram
+		conn           histogram
+		dns            histogram
+		reqBefore      histogram
+		req            histogram
+		delay          histogram
+		resAfter       histogram
+		res            histogram
+		statusCodeDist map[int]int
+		errorDist      map[string]int
+		sizeTotal      int64
+		checkFailures  int64
+		skipped        int64
+		aborts         int64
+		abortReasons   map[string]int
+		checkPassed    []int64
+		checkFailed    []int64
+	}
+	// stageStats is the aggregate of the transactions started in a stage.
+	stageStats struct {
+		count    int
+		errCount int
+		duration time.Duration
+	}
+	// scenarioStats is the aggregate of the transactions of a scenario of the mix.
+	scenarioStats struct {
+		duration histogram
+		errCount int64
+	}
+)
+
+func newStats(steps, stages, scenarios int) *stats {
+	s := &stats{
+		steps:     make([]*stepStats, steps),
+		stages:    make([]*stageStats, stages),
+		scenarios: make([]*scenarioStats, scenarios),
+	}
+	for i := range s.steps {
+		s.steps[i] = &stepStats{
+			statusCodeDist: make(map[int]int),
+			errorDist:      make(map[string]int),
+			abortReasons:   make(map[string]int),
+		}
+	}
+	for i := range s.stages {
+		s.stages[i] = &stageStats{}
+	}
+	for i := range s.scenarios {
+		s.scenarios[i] = &scenarioStats{}
+	}
+	return s
+}
+
+// add records the result of a transaction started at offset after the start of the task.
+func (s *stats) add(result *Result, offset time.Duration) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.duration.record(result.Duration)
+	if result.CorrectedDuration > 0 {
+		s.corrected.record(result.CorrectedDuration)
+	}
+	var failed bool
+	for _, res := range result.Details {
+		step := s.steps[result.step+res.Step]
+		if res.Skipped {
+			step.skipped++
+			continue
+		}
+		if res.Err != nil {
+			failed = true
+			step.errorDist[res.Err.Error()]++
+			continue
+		}
+		s.reqBeforeTotal += res.ReqBeforeDuration
+		s.resAfterTotal += res.ResAfterDuration
+		step.duration.record(res.Duration)
+		step.conn.record(res.ConnDuration)
+		step.dns.record(res.DNSDuration)
+		step.reqBefore.record(res.ReqBeforeDuration)
+		step.req.record(res.ReqDuration)
+		step.delay.record(res.DelayDuration)
+		step.resAfter.record(res.ResAfterDuration)
+		step.res.record(res.ResDuration)
+		step.statusCodeDist[res.StatusCode]++
+		if res.ContentLength > 0 {
+			step.sizeTotal += res.ContentLength
+		}
+		if res.Failed() {
+			failed = true
+			step.checkFailures++
+		}
+		for j, passed := range res.Checks {
+			for len(step.checkPassed) <= j {
+				step.checkPassed = append(step.checkPassed, 0)
+				step.checkFailed = append(step.checkFailed, 0)
+			}
+			if passed {
+				step.checkPassed[j]++
+			} else {
+				step.checkFailed[j]++
+			}
+		}
+	}
+	if failed {
+		s.errCount++
+	}
+	if result.Aborted {
+		s.aborted.record(result.Duration)
+		// The transaction is aborted at its last sent request.
+		for j := len(result.Details) - 1; j >= 0; j-- {
+			if res := result.Details[j]; !res.Skipped || j == 0 {
+				step := s.steps[result.step+res.Step]
+				step.aborts++
+				step.abortReasons[result.AbortReason]++
+				break
+			}
+		}
+	}
+	if s.timeline != nil {
+		i := s.timeline.index(offset)
+		if s.open != nil && i != s.openIndex {
+			s.timeline.merge(s.openIndex, s.open)
+			s.open = nil
+		}
+		if s.open == nil {
+			s.open = newTimeBucket(len(s.steps))
+			s.openIndex = i
+		}
+		s.open.add(result, failed)
+		// The requests are in the interval they started in, which is later
+		// than the start of the transaction after a think time or a slow step.
+		for _, res := range result.Details {
+			if res.Skipped {
+				continue
+			}
+			step := result.step + res.Step
+			if j := s.timeline.index(offset + res.Start.Sub(result.Start)); j != i {
+				s.timeline.addStep(j, len(s.steps), step, res)
+				continue
+			}
+			s.open.addStep(step, res)
+		}
+	}
+	if s.rolling {
+		s.window.record(result.Duration)
+		if failed {
+			s.windowErrCount++
+		}
+	}
+	if result.Stage < len(s.stages) {
+		stage := s.stages[result.Stage]
+		stage.count++
+		stage.duration += result.Duration
+		if failed {
+			stage.errCount++
+		}
+	}
+	if result.Scenario < len(s.scenarios) {
+		scenario := s.scenarios[result.Scenario]
+		scenario.duration.record(result.Duration)
+		if failed {
+			scenario.errCount++
+		}
+	}
+}
+
+// merge adds the aggregate of o to s.
+func (s *stats) merge(o *stats) {
+	o.mx.Lock()
+	defer o.mx.Unlock()
+	s.duration.merge(&o.duration)
+	s.corrected.merge(&o.corrected)
+	s.aborted.merge(&o.aborted)
+	s.errCount += o.errCount
+	s.reqBeforeTotal += o.reqBeforeTotal
+	s.resAfterTotal += o.resAfterTotal
+	for i, step := range o.steps {
+		s.steps[i].duration.merge(&step.duration)
+		s.steps[i].conn.merge(&step.conn)
+		s.steps[i].dns.merge(&step.dns)
+		s.steps[i].reqBefore.merge(&step.reqBefore)
+		s.steps[i].req.merge(&step.req)
+		s.steps[i].delay.merge(&step.delay)
+		s.steps[i].resAfter.merge(&step.resAfter)
+		s.steps[i].res.merge(&step.res)
+		for code, n := range step.statusCodeDist {
+			s.steps[i].statusCodeDist[code] += n
+		}
+		for err, n := range step.errorDist {
+			s.steps[i].errorDist[err] += n
+		}
+		s.steps[i].sizeTotal += step.sizeTotal
+		s.steps[i].checkFailures += step.checkFailures
+		s.steps[i].skipped += step.skipped
+		s.steps[i].aborts += step.aborts
+		for reason, n := range step.abortReasons {
+			s.steps[i].abortReasons[reason] += n
+		}
+		for j := range step.checkPassed {
+			for len(s.steps[i].checkPassed) <= j {
+				s.steps[i].checkPassed = append(s.steps[i].checkPassed, 0)
+				s.steps[i].checkFailed = append(s.steps[i].checkFailed, 0)
+			}
+			s.steps[i].checkPassed[j] += step.checkPassed[j]
+			s.steps[i].checkFailed[j] += step.checkFailed[j]
+		}
+	}
+	if o.open != nil {
+		o.timeline.merge(o.openIndex, o.open)
+		o.open = nil
+	}
+	for i, stage := range o.stages {
+		s.stages[i].count += stage.count
+		s.stages[i].errCount += stage.errCount
+		s.stages[i].duration += stage.duration
+	}
+	for i, scenario := range o.scenarios {
+		s.scenarios[i].duration.merge(&scenario.duration)
+		s.scenarios[i].errCount += scenario.errCount
+	}
+}
@@ -2,17 +2,19 @@ package stress
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptrace"
 	"net/url"
 	"os"
-	"os/signal"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/http2"
@@ -27,13 +29,70 @@ type (
 		Concurrent int
 		// Duration is the duration of requests.
 		Duration time.Duration
+		// Rate is the number of transactions to start per second.
+		// If greater than 0, transactions are started on a fixed timeline
+		// regardless of response times, and Concurrent caps the number
+		// of in-flight transactions.
+		Rate int
+		// Stages is the load profile of the task, the stages are run one after another.
+		// If set, Number and Duration must not be set, each stage ramps the
+		// concurrency or the rate linearly from the previous target to its own.
+		Stages []Stage
+		// Percentiles is the latency percentiles to report, such as 99.9.
+		// Default value is 50, 90, 95, 99 and 99.9.
+		Percentiles []float64
 		// Output is the report output directory.
-		// The output contains the summary information file and the CSV file for each request.
+		// The output contains the summary information file, the CSV file for each request,
+		// the time series of each interval and index.html, the HTML report with charts.
 		Output string
+		// Interval is the interval of the time series, by transaction start time.
+		// Default value is 1 second.
+		Interval time.Duration
+		// Format is the format of the default report, FormatText or FormatJSON.
+		// Default value is FormatText.
+		Format string
 		// Processing result reporting function.
 		// If the function is passed in, the incoming function is used to process the report,
 		// otherwise the default function is used to process the report.
+		// Passing the function retains the result of every transaction in memory,
+		// the default report only keeps aggregates and its memory does not grow with the run.
 		ReportHandler func(results []*Result, totalTime time.Duration)
+		// Quiet does not print the default report nor write it to Output,
+		// the report is only returned in the RunResult.
+		Quiet bool
+		// KeepResults retains the result of every transaction in the RunResult,
+		// they are always retained if ReportHandler is passed.
+		KeepResults bool
+		// OnProgress is called every second while the task is running.
+		OnProgress func(snapshot Snapshot)
+		// OnResult is called with the result of each transaction as it ends,
+		// concurrently by the requesters.
+		OnResult func(result *Result)
+		// MetricsAddr is the address to serve Prometheus metrics on /metrics while
+		// the task is running, such as ":9102". If empty, metrics are not served.
+		MetricsAddr string
+		// Feeder hands each transaction a row of data, stored in its Share.
+		Feeder *Feeder
+		// Cookies keeps the cookies of the responses in a cookie jar of each requester
+		// (virtual user) and sends them with its next requests, for CookiesTransaction
+		// until the end of each transaction and for CookiesUser across its transactions.
+		// Default value is empty, the cookies are not kept.
+		Cookies string
+		// Setup is called once before the requesters start, its data is passed to
+		// the virtual users and to Teardown. If it fails, the task is not run.
+		Setup func() (data interface{}, err error)
+		// Teardown is called once after the requesters end, with the data of Setup.
+		Teardown func(data interface{})
+		// OnStart is called by each requester (virtual user) before its first
+		// transaction, such as to log in once and keep the token in its Store.
+		OnStart func(vu *VU)
+		// OnStop is called by each requester after its last transaction.
+		OnStop func(vu *VU)
+		// Thresholds are the pass or fail criteria of the transactions, evaluated at
+		// the end of the task, such as "p99<300ms", "errors<1%" or "rps>800".
+		Thresholds []string
+		// Abort stops the task before its end when the error rate or the latency exceeds a limit.
+		Abort *Abort
 
 		// Global configuration, if the configuration is not specified in RequestConfig,
 		// use the settings global configuration.
@@ -53,13 +112,8 @@ type (
 		DisableKeepAlives bool
 		// DisableRedirects is an option to prevent the following of HTTP redirects.
 		DisableRedirects bool
-
-		// The total think time required for all requests.
-		thinkDuration time.Duration
-		reqConfigs    []*RequestConfig
-		start         time.Time
-		results       []*Result
-		mx            sync.Mutex
+		// AbortOnFailure is an option to abort the transaction at a failed request.
+		AbortOnFailure bool
 	}
 	// RequestConfig is the request of configuration.
 	RequestConfig struct {
@@ -74,6 +128,33 @@ type (
 		// Events is the custom event in the request.
 		// Contains the function before the request and the function after the response.
 		Events *Events
+		// Extractors store values of the response into the Share of the transaction,
+		// before the function after the response.
+		Extractors []*Extractor
+		// Checks are the assertions on the response, a request that fails a check is failed.
+		Checks []*Check
+		// Thresholds are the pass or fail criteria of the request, such as "p99<300ms".
+		Thresholds []string
+
+		// Control flow of the request in the transaction, the requests are sent in order by default.
+		// Name is the name of the request, unique in the transaction, to jump to it.
+		Name string
+		// AbortOnFailure is an option to abort the transaction if the request fails,
+		// with an error or a failed check, the next requests are skipped.
+		AbortOnFailure bool
+		// Skip skips the request if it returns true, such as when a value is not in the share.
+		Skip func(share Share) bool
+		// Repeat is the number of times to send the request. Default value is 1.
+		Repeat int
+		// Until stops the repeats of the request once it returns true after a request.
+		// Without Repeat, the request is repeated until it returns true, the transaction
+		// is aborted if the task is stopped or its Duration or Stages end before.
+		Until func(share Share) bool
+		// Next returns the name of the request to go on with after this one,
+		// or an empty name for the next request. A jump to an unknown name aborts
+		// the transaction with the reason `no step named "name"`, a jump back aborts
+		// it once the task is stopped or its Duration or Stages end.
+		Next func(share Share) string
 
 		// Timeout is the timeout of request in seconds.
 		Timeout int
@@ -92,86 +173,427 @@ type (
 		// DisableRedirects is an option to prevent the following of HTTP redirects.
 		DisableRedirects bool
 
-		request *http.Request
-		client  *http.Client
+		request    *http.Request
+		template   *requestTemplate
+		thresholds []*threshold
+		client     *http.Client
+	}
+	// RunResult is the result of a run of a task.
+	RunResult struct {
+		// Report is the summary of the run.
+		Report *Report
+		// Results is the result of every transaction, only retained
+		// if KeepResults is set or ReportHandler is passed.
+		Results []*Result
+		// Errors is the number of requests without response of all the requests, by error.
+		Errors map[string]int
+	}
+	// runner is a run of a task, it holds the state of the run so that
+	// the task is not modified and can be run repeatedly or concurrently.
+	runner struct {
+		*Task
+		// The total think time required for all requests, in nanoseconds.
+		thinkDuration int64
+		// missed is the number of paced starts not sent before the end of the task.
+		missed int64
+		// reqConfigs is the copy of the request configs with the defaults of the task.
+		reqConfigs   []*RequestConfig
+		timeline     *timeline
+		start        time.Time
+		ctx          context.Context
+		results      []*Result
+		workers      []*stats
+		inFlight     int64
+		metrics      *metrics
+		csvWriters   []*csvWriter
+		stopped      chan struct{}
+		thresholds   []*threshold
+		feed         *feed
+		data         interface{}
+		mix          *mix
+		source       <-chan *Result
+		abortWatcher *abortWatcher
+		aborted      string
+		mx           sync.Mutex
 	}
 )
 
-// Run is run a task.
-func (t *Task) Run(config *RequestConfig) error {
-	t.reqConfigs = append([]*RequestConfig(nil), config)
-	return t.run()
+// Run is run a task and returns its result.
+func (t *Task) Run(config *RequestConfig) (*RunResult, error) {
+	return t.RunContext(context.Background(), config)
 }
 
-// RunTran is run a transactional task.
-func (t *Task) RunTran(configs ...*RequestConfig) error {
-	t.reqConfigs = append([]*RequestConfig(nil), configs...)
-	return t.run()
+// RunTran is run a transactional task and returns its result.
+func (t *Task) RunTran(configs ...*RequestConfig) (*RunResult, error) {
+	return t.RunTranContext(context.Background(), configs...)
 }
 
-func (t *Task) run() error {
-	if err := t.checkAndInitConfigs(); err != nil {
-		return err
+// RunContext is Run, stopped when ctx is done. The requests in progress are
+// canceled and their transactions are not reported, the result of the
+// transactions until then is returned with ctx.Err().
+func (t *Task) RunContext(ctx context.Context, config *RequestConfig) (*RunResult, error) {
+	return t.RunTranContext(ctx, config)
+}
+
+// RunTranContext is RunTran, stopped when ctx is done as RunContext.
+func (t *Task) RunTranContext(ctx context.Context, configs ...*RequestConfig) (*RunResult, error) {
+	r := &runner{
+		Task:       t,
+		reqConfigs: append([]*RequestConfig(nil), configs...),
+		ctx:        ctx,
+	}
+	if err := r.checkAndInitConfigs(); err != nil {
+		return nil, err
+	}
+	return r.run()
+}
+
+func (r *runner) run() (*RunResult, error) {
+	if r.Setup != nil {
+		data, err := r.Setup()
+		if err != nil {
+			return nil, err
+		}
+		r.data = data
+	}
+	if r.Teardown != nil {
+		defer r.Teardown(r.data)
+	}
+	r.start = time.Now()
+	if r.MetricsAddr != "" {
+		m, err := r.startMetrics(r.MetricsAddr)
+		if err != nil {
+			return nil, err
+		}
+		defer m.stop()
+		r.metrics = m
+	}
+	// Open the CSV files last, they are closed by finish.
+	if r.Output != "" {
+		writers, err := newCSVWriters(r.Output, r.reqConfigs)
+		if err != nil {
+			return nil, err
+		}
+		r.csvWriters = writers
 	}
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	r.makeHTTPClient()
+	var progress sync.WaitGroup
+	done := make(chan struct{})
+	progress.Add(1)
 	go func() {
-		<-c
-		t.finish()
-		os.Exit(1)
+		select {
+		case <-r.ctx.Done():
+			r.stop()
+		case <-done:
+		}
+		progress.Done()
 	}()
-	t.start = time.Now()
-	t.makeHTTPClient()
-	t.runRequesters()
-	t.finish()
-	return nil
+	if r.OnProgress != nil {
+		progress.Add(1)
+		go func() {
+			r.reportProgress(done)
+			progress.Done()
+		}()
+	}
+	if r.abortWatcher != nil {
+		progress.Add(1)
+		go func() {
+			r.watchAbort(done)
+			progress.Done()
+		}()
+	}
+	// Stop the task at its end, for the repeats and loops of the transactions in progress.
+	if end := r.end(); end > 0 && r.source == nil {
+		timer := time.AfterFunc(r.start.Add(end).Sub(time.Now()), r.stop)
+		defer timer.Stop()
+	}
+	r.runRequesters()
+	close(done)
+	progress.Wait()
+	result := &RunResult{
+		Report:  r.finish(),
+		Results: r.results,
+		Errors:  make(map[string]int),
+	}
+	for _, step := range result.Report.Steps {
+		for err, n := range step.Errors {
+			result.Errors[err] += n
+		}
+	}
+	return result, r.ctx.Err()
+}
+
+func (r *runner) finish() *Report {
+	for _, w := range r.csvWriters {
+		w.close()
+	}
+	total := time.Now().Sub(r.start) - time.Duration(atomic.LoadInt64(&r.thinkDuration))
+	report := newReport(r, r.collectStats(), total)
+	if r.Number < 0 && r.ReportHandler == nil {
+		return report
+	}
+	if r.ReportHandler != nil {
+		r.mx.Lock()
+		results := r.results
+		r.mx.Unlock()
+		r.ReportHandler(results, total)
+	} else if !r.Quiet {
+		report.print(r.Format, r.Output)
+	}
+	return report
+}
+
+// workerStats returns the stats the requester no records into.
+func (r *runner) workerStats(no int) *stats {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	for len(r.workers) <= no {
+		s := newStats(len(r.reqConfigs), len(r.Stages), r.scenarios())
+		s.rolling = r.OnProgress != nil
+		s.timeline = r.timeline
+		r.workers = append(r.workers, s)
+	}
+	return r.workers[no]
 }
 
-func (t *Task) finish() {
-	if t.Number < 0 && t.ReportHandler == nil {
+// collectStats merges the stats of all requesters.
+func (r *runner) collectStats() *stats {
+	total := newStats(len(r.reqConfigs), len(r.Stages), r.scenarios())
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	for _, w := range r.workers {
+		total.merge(w)
+	}
+	total.timeline = r.timeline
+	return total
+}
+
+// record saves the result of a transaction, result is nil if the transaction was not sent.
+func (r *runner) record(s *stats, result *Result) {
+	if result == nil {
 		return
 	}
-	total := time.Now().Sub(t.start) - t.thinkDuration
-	if t.ReportHandler != nil {
-		t.ReportHandler(t.results, total)
-	} else {
-		newReport(t.results, t.Output, total).finalize()
+	if r.abortWatcher != nil {
+		if reason := r.abortWatcher.add(result, result.failed(), time.Now().Sub(r.start)); reason != "" {
+			r.abort(reason)
+		}
+	}
+	s.add(result, result.Start.Sub(r.start))
+	if r.metrics != nil {
+		r.metrics.add(result)
+	}
+	if r.csvWriters != nil {
+		for _, res := range result.Details {
+			r.csvWriters[result.step+res.Step].write(res)
+		}
+	}
+	if r.ReportHandler != nil || r.KeepResults {
+		r.mx.Lock()
+		r.results = append(r.results, result)
+		r.mx.Unlock()
+	}
+	if r.OnResult != nil {
+		r.OnResult(result)
 	}
 }
 
-func (t *Task) runRequesters() {
+func (r *runner) runRequesters() {
+	if r.source != nil {
+		r.collect()
+		return
+	}
+	if len(r.Stages) > 0 {
+		if stagedRate(r.Stages) {
+			r.runPacedRequesters(r.scheduleStages)
+		} else {
+			r.runStagedRequesters()
+		}
+		return
+	}
+	if r.Rate > 0 {
+		r.runPacedRequesters(r.scheduleRate)
+		return
+	}
 	var wg sync.WaitGroup
-	wg.Add(t.Concurrent)
+	wg.Add(r.Concurrent)
 
-	for i := 0; i < t.Concurrent; i++ {
+	for i := 0; i < r.Concurrent; i++ {
 		go func(routineNum int) {
-			t.runRequester(t.Number/t.Concurrent, routineNum)
+			r.runRequester(r.Number/r.Concurrent, routineNum)
 			wg.Done()
 		}(i)
 	}
 	wg.Wait()
 }
 
-func (t *Task) runRequester(num, no int) {
+func (r *runner) runRequester(num, no int) {
+	s := r.workerStats(no)
+	vu := r.startVU(no)
+	defer r.stopVU(vu)
 	i := 0
-	if t.Duration > 0 || t.Number < 0 {
+	if r.Duration > 0 || r.Number < 0 {
 		for {
-			if t.Duration > 0 && time.Now().Sub(t.start) >= t.Duration {
+			if r.Duration > 0 && time.Now().Sub(r.start) >= r.Duration || r.isStopped() {
 				break
 			}
-			t.sendRequest(no, i)
+			r.record(s, r.sendRequest(vu, i, tick{}))
 			i++
 		}
 		return
 	}
-	for ; i < num; i++ {
-		t.sendRequest(no, i)
+	for ; i < num && !r.isStopped(); i++ {
+		r.record(s, r.sendRequest(vu, i, tick{}))
+	}
+}
+
+// tick is a transaction start, at is the scheduled time of the start
+// and is zero if the transaction is not paced.
+type tick struct {
+	at    time.Time
+	stage int
+}
+
+// runPacedRequesters hands each transaction start produced by schedule
+// to an idle requester, so Concurrent caps the in-flight transactions.
+func (r *runner) runPacedRequesters(schedule func(ticks chan<- tick)) {
+	ticks := make(chan tick)
+	var wg sync.WaitGroup
+	wg.Add(r.Concurrent)
+
+	for i := 0; i < r.Concurrent; i++ {
+		go func(routineNum int) {
+			s := r.workerStats(routineNum)
+			vu := r.startVU(routineNum)
+			index := 0
+			for tk := range ticks {
+				if !r.isStopped() {
+					r.record(s, r.sendRequest(vu, index, tk))
+				} else if r.over() {
+					atomic.AddInt64(&r.missed, 1)
+				}
+				index++
+			}
+			r.stopVU(vu)
+			wg.Done()
+		}(i)
+	}
+	schedule(ticks)
+	close(ticks)
+	wg.Wait()
+}
+
+// scheduleRate schedules transaction starts at the fixed Rate.
+func (r *runner) scheduleRate(ticks chan<- tick) {
+	interval := float64(time.Second) / float64(r.Rate)
+	for i := 0; r.Number <= 0 || i < r.Number; i++ {
+		offset := time.Duration(float64(i) * interval)
+		if r.Duration > 0 && offset >= r.Duration {
+			break
+		}
+		if !r.sendLateTick(ticks, r.tickAt(offset, 0)) {
+			return
+		}
+	}
+}
+
+// tickAt waits until offset after the start of the task.
+func (r *runner) tickAt(offset time.Duration, stage int) tick {
+	at := r.start.Add(offset)
+	if wait := at.Sub(time.Now()); wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-r.stopped:
+			timer.Stop()
+		}
+	}
+	return tick{at: at, stage: stage}
+}
+
+// sendTick hands tk to a requester, it returns false if the task is stopped.
+func (r *runner) sendTick(ticks chan<- tick, tk tick) bool {
+	select {
+	case ticks <- tk:
+		return true
+	case <-r.stopped:
+		return false
+	}
+}
+
+// sendLateTick is sendTick for a start that may be late, a start after
+// the end of the task is counted as missed instead of being sent.
+func (r *runner) sendLateTick(ticks chan<- tick, tk tick) bool {
+	if !r.over() && r.sendTick(ticks, tk) {
+		return true
+	}
+	if r.over() {
+		atomic.AddInt64(&r.missed, 1)
+		return true
+	}
+	return false
+}
+
+// stop stops the requesters before the end of the task,
+// the transactions in progress are completed.
+func (r *runner) stop() {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	select {
+	case <-r.stopped:
+	default:
+		close(r.stopped)
+	}
+}
+
+// end returns the duration of the task, or of its stages, 0 if it runs for a Number of transactions.
+func (r *runner) end() time.Duration {
+	end := r.Duration
+	for _, stage := range r.Stages {
+		end += stage.Duration
+	}
+	return end
+}
+
+// over reports whether the Duration or the Stages of the task are over.
+func (r *runner) over() bool {
+	end := r.end()
+	return end > 0 && !time.Now().Before(r.start.Add(end))
+}
+
+func (r *runner) isStopped() bool {
+	select {
+	case <-r.stopped:
+		return true
+	default:
+		return false
 	}
 }
 
-func (t *Task) makeHTTPClient() {
+// scenarios is the number of scenarios of the mix, 0 if the task is not a mix.
+func (r *runner) scenarios() int {
+	if r.mix == nil {
+		return 0
+	}
+	return len(r.mix.scenarios)
+}
+
+// requesters is the largest number of requesters of the task.
+func (t *Task) requesters() int {
+	if len(t.Stages) == 0 || stagedRate(t.Stages) {
+		return t.Concurrent
+	}
+	n := 0
+	for _, stage := range t.Stages {
+		if stage.Concurrent > n {
+			n = stage.Concurrent
+		}
+	}
+	return n
+}
+
+func (r *runner) makeHTTPClient() {
 	// Create http.Client.
-	for i, reqConfig := range t.reqConfigs {
+	for i, reqConfig := range r.reqConfigs {
 		transport := &http.Transport{
 			TLSClientConfig: &tls.Config{
 				InsecureSkipVerify: true,
@@ -194,109 +616,267 @@ func (t *Task) makeHTTPClient() {
 				return http.ErrUseLastResponse
 			}
 		}
-		t.reqConfigs[i].client = client
+		r.reqConfigs[i].client = client
 	}
 }
 
-func (t *Task) sendRequest(no, index int) {
-	// init share and results.
-	len := len(t.reqConfigs)
-	share := make(Share, len)
+func (r *runner) sendRequest(vu *VU, index int, tk tick) *Result {
+	atomic.AddInt64(&r.inFlight, 1)
+	defer atomic.AddInt64(&r.inFlight, -1)
+	// Pick the scenario of the transaction, init share and results.
+	var scenario, first int
+	steps := len(r.reqConfigs)
+	if r.mix != nil {
+		scenario = r.mix.pick()
+		first, steps = r.mix.steps(scenario)
+	}
+	share := make(Share, steps)
+	if r.Feeder != nil {
+		row, ok := r.feed.row(vu.No)
+		if !ok {
+			r.stop()
+			return nil
+		}
+		for k, v := range row {
+			share[k] = v
+		}
+	}
 	results := &Result{
-		Details: make([]*ResultDetail, len),
+		Details:  make([]*ResultDetail, 0, steps),
+		Stage:    tk.stage,
+		Scenario: scenario,
+		step:     first,
+	}
+	jar := r.cookieJar(vu)
+	var events *Events
+	if steps > 0 {
+		events = r.reqConfigs[first].Events
 	}
 	tranStart := time.Now()
+	results.Start = tranStart
+	// Handle custom event: function before the transaction.
+	if events != nil && events.TransactionStart != nil {
+		events.TransactionStart(share)
+	}
 	var thinkDuration time.Duration
-	for i, reqConfig := range t.reqConfigs {
-		start := time.Now()
-		var size int64
-		var code int
-		var dnsStart, connStart, reqStart, resStart, delayStart, reqBeforeStart, resAfterStart time.Time
-		var dnsDuration, connDuration, reqDuration, resDuration, delayDuration, reqBeforeDuration, resAfterDuration time.Duration
-		req := cloneRequest(reqConfig.request, reqConfig.ReqBody)
-		req.Host = reqConfig.Host
-		// Handle custom event: function before the request.
-		reqBeforeStart = time.Now()
-		if reqConfig.Events != nil && reqConfig.Events.RequestBefore != nil {
-			reqInfo := &Request{
-				GoRoutineNo: no,
-				Index:       index,
-				Req:         req,
-			}
-			reqConfig.Events.RequestBefore(reqInfo, share)
+	configs := r.reqConfigs[first : first+steps]
+	// Send the requests in order, following the control flow of the steps.
+	for i, sent := 0, 0; i < len(configs) && r.ctx.Err() == nil; {
+		reqConfig := configs[i]
+		if reqConfig.Skip != nil && reqConfig.Skip(share) {
+			results.Details = skipRequests(results.Details, configs[i:i+1], i)
+			i++
+			sent = 0
+			continue
 		}
-		reqBeforeDuration = time.Now().Sub(reqBeforeStart)
-		// Create httptrace.
-		trace := &httptrace.ClientTrace{
-			DNSStart: func(httptrace.DNSStartInfo) {
-				dnsStart = time.Now()
-			},
-			DNSDone: func(httptrace.DNSDoneInfo) {
-				dnsDuration = time.Now().Sub(dnsStart)
-			},
-			GetConn: func(h string) {
-				connStart = time.Now()
-			},
-			GotConn: func(connInfo httptrace.GotConnInfo) {
-				connDuration = time.Now().Sub(connStart)
-				reqStart = time.Now()
-			},
-			WroteRequest: func(w httptrace.WroteRequestInfo) {
-				reqDuration = time.Now().Sub(reqStart)
-				delayStart = time.Now()
-			},
-			GotFirstResponseByte: func() {
-				delayDuration = time.Now().Sub(delayStart)
-				resStart = time.Now()
-			},
+		res, reqInfo := r.sendStep(vu, index, reqConfig, share, jar)
+		if res == nil {
+			results.Details = skipRequests(results.Details, configs[i:], i)
+			break
 		}
-		req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
-		res, err := reqConfig.client.Do(req)
-		if err == nil {
-			size = res.ContentLength
-			code = res.StatusCode
-			// Handle custom event: function after the response.
-			resAfterStart = time.Now()
-			if reqConfig.Events != nil && reqConfig.Events.ResponseAfter != nil {
-				reqConfig.Events.ResponseAfter(res, share)
-			}
-			resAfterDuration = time.Now().Sub(resAfterStart)
-			io.Copy(ioutil.Discard, res.Body)
-			res.Body.Close()
+		res.Step = i
+		results.Details = append(results.Details, res)
+		if reqInfo.skip {
+			results.Details = skipRequests(results.Details, configs[i+1:], i+1)
+			break
 		}
-		nowTime := time.Now()
-		resDuration = nowTime.Sub(resStart)
-		end := nowTime.Sub(start)
-		results.Details[i] = &ResultDetail{
-			URLStr:            req.URL.String(),
-			Method:            req.Method,
-			Err:               err,
-			StatusCode:        code,
-			Duration:          end - reqBeforeDuration - resAfterDuration,
-			ConnDuration:      connDuration,
-			DNSDuration:       dnsDuration,
-			ReqDuration:       reqDuration,
-			ResDuration:       resDuration,
-			DelayDuration:     delayDuration,
-			ReqBeforeDuration: reqBeforeDuration,
-			ResAfterDuration:  resAfterDuration,
-			ContentLength:     size,
+		if reqConfig.AbortOnFailure && res.Failed() {
+			results.Aborted, results.AbortReason = true, AbortFailure
+			results.Details = skipRequests(results.Details, configs[i+1:], i+1)
+			break
 		}
 		// Handle think time.
 		thinktime := time.Duration(reqConfig.ThinkTime) * time.Second
-		time.Sleep(thinktime)
+		if thinktime > 0 {
+			timer := time.NewTimer(thinktime)
+			select {
+			case <-timer.C:
+			case <-r.ctx.Done():
+				timer.Stop()
+			}
+		}
 		thinkDuration += thinktime
-		t.thinkDuration += thinktime
+		atomic.AddInt64(&r.thinkDuration, int64(thinktime))
+		// Repeat the request, or go on to the next or the named step.
+		sent++
+		if reqConfig.Until == nil || !reqConfig.Until(share) {
+			if sent < reqConfig.Repeat {
+				continue
+			}
+			if reqConfig.Repeat == 0 && reqConfig.Until != nil {
+				if r.isStopped() {
+					results.Aborted, results.AbortReason = true, AbortStopped
+					break
+				}
+				continue
+			}
+		}
+		sent = 0
+		next := i + 1
+		if reqConfig.Next != nil {
+			if name := reqConfig.Next(share); name != "" {
+				if next = stepIndex(configs, name); next < 0 {
+					results.Aborted, results.AbortReason = true, fmt.Sprintf("no step named %q", name)
+					break
+				}
+			}
+		}
+		// A jump back, which may loop, ends with the task.
+		if next <= i && r.isStopped() {
+			results.Aborted, results.AbortReason = true, AbortStopped
+			break
+		}
+		i = next
 	}
-	finish := time.Now().Sub(tranStart)
-	results.Duration = finish - thinkDuration
-	// Save request result.
-	if t.Number < 0 && t.ReportHandler == nil {
-		return
+	tranEnd := time.Now()
+	results.Duration = tranEnd.Sub(tranStart) - thinkDuration
+	if !tk.at.IsZero() {
+		// Measure from the scheduled start, so the time spent waiting
+		// behind slow transactions is not omitted.
+		results.CorrectedDuration = tranEnd.Sub(tk.at) - thinkDuration
+	}
+	// Handle custom event: function after the transaction.
+	if events != nil && events.TransactionEnd != nil {
+		events.TransactionEnd(results, share)
+	}
+	// The transaction is interrupted by the cancellation of the task.
+	if r.ctx.Err() != nil {
+		return nil
+	}
+	return results
+}
+
+// sendStep sends the request of reqConfig in the transaction of vu, the detail is nil
+// if the request is skipped by the function before the request.
+func (r *runner) sendStep(vu *VU, index int, reqConfig *RequestConfig, share Share, jar http.CookieJar) (*ResultDetail, *Request) {
+	start := time.Now()
+	var size int64
+	var code int
+	var dnsStart, connStart, reqStart, resStart, delayStart, reqBeforeStart, resAfterStart time.Time
+	var dnsDuration, connDuration, reqDuration, resDuration, delayDuration, reqBeforeDuration, resAfterDuration time.Duration
+	req := cloneRequest(reqConfig.request, reqConfig.ReqBody)
+	// Render the templates of the request.
+	var err error
+	if reqConfig.template != nil {
+		err = reqConfig.template.render(req, &templateData{
+			Share:       share,
+			VU:          vu,
+			GoRoutineNo: vu.No,
+			Index:       index,
+		})
+	}
+	req.Host = reqConfig.Host
+	// Handle custom event: function before the request.
+	reqBeforeStart = time.Now()
+	reqInfo := &Request{
+		GoRoutineNo: vu.No,
+		Index:       index,
+		VU:          vu,
+		Req:         req,
+	}
+	if reqConfig.Events != nil && reqConfig.Events.RequestBefore != nil {
+		reqConfig.Events.RequestBefore(reqInfo, share)
+	}
+	reqBeforeDuration = time.Now().Sub(reqBeforeStart)
+	if reqInfo.skip {
+		return nil, reqInfo
+	}
+	if err == nil {
+		err = reqInfo.err
+	}
+	// Create httptrace.
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			dnsDuration = time.Now().Sub(dnsStart)
+		},
+		GetConn: func(h string) {
+			connStart = time.Now()
+		},
+		GotConn: func(connInfo httptrace.GotConnInfo) {
+			connDuration = time.Now().Sub(connStart)
+			reqStart = time.Now()
+		},
+		WroteRequest: func(w httptrace.WroteRequestInfo) {
+			reqDuration = time.Now().Sub(reqStart)
+			delayStart = time.Now()
+		},
+		GotFirstResponseByte: func() {
+			delayDuration = time.Now().Sub(delayStart)
+			resStart = time.Now()
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(r.ctx, trace))
+	var res *http.Response
+	var body []byte
+	if err == nil {
+		res, err = withJar(reqConfig.client, jar).Do(req)
+	}
+	if err == nil {
+		size = res.ContentLength
+		code = res.StatusCode
+		// Read the body for the extractors and checks, and keep it readable for the event.
+		if readsBody(reqConfig.Extractors) || checksBody(reqConfig.Checks) {
+			body, err = ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			res.Body = ioutil.NopCloser(bytes.NewReader(body))
+			if size < 0 {
+				size = int64(len(body))
+			}
+		}
+		// Handle extractors and custom event: function after the response.
+		resAfterStart = time.Now()
+		if err == nil && reqConfig.Extractors != nil {
+			err = extract(reqConfig.Extractors, res, body, share)
+		}
+		if reqConfig.Events != nil && reqConfig.Events.ResponseAfter != nil {
+			reqConfig.Events.ResponseAfter(res, share)
+		}
+		if reqConfig.Events != nil && reqConfig.Events.OnResponse != nil {
+			reqConfig.Events.OnResponse(reqInfo, res, share)
+			if reqInfo.err != nil {
+				err = reqInfo.err
+			}
+		}
+		resAfterDuration = time.Now().Sub(resAfterStart)
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
 	}
-	t.mx.Lock()
-	t.results = append(t.results, results)
-	t.mx.Unlock()
+	nowTime := time.Now()
+	resDuration = nowTime.Sub(resStart)
+	end := nowTime.Sub(start)
+	duration := end - reqBeforeDuration - resAfterDuration
+	// Handle checks of the response.
+	var checks []bool
+	if err == nil && reqConfig.Checks != nil {
+		checks = runChecks(reqConfig.Checks, res, body, duration)
+	}
+	// Handle custom event: function after a failed request.
+	if err != nil && reqConfig.Events != nil && reqConfig.Events.OnError != nil {
+		reqConfig.Events.OnError(reqInfo, err, share)
+		if reqInfo.err != nil {
+			err = reqInfo.err
+		}
+	}
+	return &ResultDetail{
+		URLStr:            req.URL.String(),
+		Method:            req.Method,
+		Start:             start,
+		Err:               err,
+		StatusCode:        code,
+		Duration:          duration,
+		ConnDuration:      connDuration,
+		DNSDuration:       dnsDuration,
+		ReqDuration:       reqDuration,
+		ResDuration:       resDuration,
+		DelayDuration:     delayDuration,
+		ReqBeforeDuration: reqBeforeDuration,
+		ResAfterDuration:  resAfterDuration,
+		ContentLength:     size,
+		Checks:            checks,
+	}, reqInfo
 }
 
 func cloneRequest(r *http.Request, body []byte) *http.Request {
@@ -312,68 +892,169 @@ func cloneRequest(r *http.Request, body []byte) *http.Request {
 	return req
 }
 
-func (t *Task) checkAndInitConfigs() error {
-	if t.Number == 0 && t.Duration <= 0 {
+func (r *runner) checkAndInitConfigs() error {
+	if len(r.reqConfigs) == 0 {
+		return errors.New("RequestConfig cannot be empty")
+	}
+	if len(r.Stages) > 0 {
+		if err := r.checkStages(); err != nil {
+			return err
+		}
+	} else if r.Number == 0 && r.Duration <= 0 {
 		return errors.New("Number or Duration cannot be smaller than 1")
 	}
-	if t.Number != 0 && t.Duration > 0 {
+	if r.Number != 0 && r.Duration > 0 {
 		return errors.New("Number and Duration only set one")
 	}
-	if t.Concurrent <= 0 {
+	if r.Concurrent <= 0 && (len(r.Stages) == 0 || stagedRate(r.Stages)) {
 		return errors.New("Concurrent cannot be smaller than 1")
 	}
-	if t.Number > 0 && t.Number < t.Concurrent {
+	if r.Rate < 0 {
+		return errors.New("Rate cannot be smaller than 0")
+	}
+	if r.Rate == 0 && r.Number > 0 && r.Number < r.Concurrent {
 		return errors.New("Number cannot be less than Concurrent")
 	}
-	if t.Number > 0 && t.Number%t.Concurrent != 0 {
+	if r.Rate == 0 && r.Number > 0 && r.Number%r.Concurrent != 0 {
 		return errors.New("Number must be an integer multiple of Concurrent")
 	}
-	if t.Output != "" {
-		err := os.MkdirAll(t.Output, 0777)
+	if r.Format != "" && r.Format != FormatText && r.Format != FormatJSON {
+		return errors.New("Format must be text or json")
+	}
+	for _, p := range r.Percentiles {
+		if p <= 0 || p > 100 {
+			return errors.New("Percentiles must be greater than 0 and not greater than 100")
+		}
+	}
+	if r.Cookies != "" && r.Cookies != CookiesTransaction && r.Cookies != CookiesUser {
+		return errors.New("Cookies must be transaction or user")
+	}
+	if r.Output != "" {
+		err := os.MkdirAll(r.Output, 0777)
 		if err != nil {
 			return err
 		}
 	}
-	if t.Duration <= 0 && t.Number > 0 {
-		t.results = make([]*Result, 0, t.Number)
+	if (r.ReportHandler != nil || r.KeepResults) && r.Duration <= 0 && r.Number > 0 {
+		r.results = make([]*Result, 0, r.Number)
+	}
+	r.stopped = make(chan struct{})
+	thresholds, err := parseThresholds(r.Thresholds)
+	if err != nil {
+		return err
+	}
+	r.thresholds = thresholds
+	if r.Abort != nil {
+		if err := r.Abort.check(); err != nil {
+			return err
+		}
+		r.abortWatcher = newAbortWatcher(r.Abort)
+	}
+	if r.Feeder != nil {
+		if r.feed, err = r.Feeder.newFeed(r.requesters()); err != nil {
+			return err
+		}
+	}
+	if r.Interval < 0 {
+		return errors.New("Interval cannot be less than 0")
 	}
-	for i, n := 0, len(t.reqConfigs); i < n; i++ {
-		if t.reqConfigs[i] == nil {
+	interval := r.Interval
+	if interval == 0 {
+		interval = defaultInterval
+	}
+	r.timeline = &timeline{interval: interval}
+	for i, n := 0, len(r.reqConfigs); i < n; i++ {
+		if r.reqConfigs[i] == nil {
 			return errors.New("RequestConfig cannot be nil")
 		}
-		if t.reqConfigs[i].URLStr == "" || t.reqConfigs[i].Method == "" {
+		config := *r.reqConfigs[i]
+		r.reqConfigs[i] = &config
+		if r.reqConfigs[i].URLStr == "" || r.reqConfigs[i].Method == "" {
 			return errors.New("URLStr and Method cannot be empty")
 		}
-		if t.Timeout > 0 && t.reqConfigs[i].Timeout <= 0 {
-			t.reqConfigs[i].Timeout = t.Timeout
+		if r.Timeout > 0 && r.reqConfigs[i].Timeout <= 0 {
+			r.reqConfigs[i].Timeout = r.Timeout
+		}
+		if r.ThinkTime > 0 && r.reqConfigs[i].ThinkTime <= 0 {
+			r.reqConfigs[i].ThinkTime = r.ThinkTime
+		}
+		if r.Host != "" && r.reqConfigs[i].Host == "" {
+			r.reqConfigs[i].Host = r.Host
 		}
-		if t.ThinkTime > 0 && t.reqConfigs[i].ThinkTime <= 0 {
-			t.reqConfigs[i].ThinkTime = t.ThinkTime
+		if r.ProxyAddr != nil && r.reqConfigs[i].ProxyAddr == nil {
+			r.reqConfigs[i].ProxyAddr = r.ProxyAddr
 		}
-		if t.Host != "" && t.reqConfigs[i].Host == "" {
-			t.reqConfigs[i].Host = t.Host
+		if r.DisableCompression && !r.reqConfigs[i].DisableCompression {
+			r.reqConfigs[i].DisableCompression = true
 		}
-		if t.ProxyAddr != nil && t.reqConfigs[i].ProxyAddr == nil {
-			t.reqConfigs[i].ProxyAddr = t.ProxyAddr
+		if r.DisableKeepAlives && !r.reqConfigs[i].DisableKeepAlives {
+			r.reqConfigs[i].DisableKeepAlives = true
 		}
-		if t.DisableCompression && !t.reqConfigs[i].DisableCompression {
-			t.reqConfigs[i].DisableCompression = true
+		if r.DisableRedirects && !r.reqConfigs[i].DisableRedirects {
+			r.reqConfigs[i].DisableRedirects = true
 		}
-		if t.DisableKeepAlives && !t.reqConfigs[i].DisableKeepAlives {
-			t.reqConfigs[i].DisableKeepAlives = true
+		if r.AbortOnFailure && !r.reqConfigs[i].AbortOnFailure {
+			r.reqConfigs[i].AbortOnFailure = true
 		}
-		if t.DisableRedirects && !t.reqConfigs[i].DisableRedirects {
-			t.reqConfigs[i].DisableRedirects = true
+		if r.reqConfigs[i].thresholds, err = parseThresholds(r.reqConfigs[i].Thresholds); err != nil {
+			return err
+		}
+		// The checks and the extractors are compiled into copies, as the request config.
+		checks := config.Checks
+		config.Checks = nil
+		for _, c := range checks {
+			if c == nil {
+				return errors.New("Check cannot be nil")
+			}
+			check := *c
+			if err := check.init(); err != nil {
+				return err
+			}
+			config.Checks = append(config.Checks, &check)
 		}
-		t.reqConfigs[i].Method = strings.ToUpper(t.reqConfigs[i].Method)
-		req, err := http.NewRequest(t.reqConfigs[i].Method, t.reqConfigs[i].URLStr, nil)
+		extractors := config.Extractors
+		config.Extractors = nil
+		for _, e := range extractors {
+			if e == nil {
+				return errors.New("Extractor cannot be nil")
+			}
+			extractor := *e
+			if err := extractor.init(); err != nil {
+				return err
+			}
+			config.Extractors = append(config.Extractors, &extractor)
+		}
+		r.reqConfigs[i].Method = strings.ToUpper(r.reqConfigs[i].Method)
+		tmpl, err := newRequestTemplate(r.reqConfigs[i])
+		if err != nil {
+			return err
+		}
+		r.reqConfigs[i].template = tmpl
+		// A templated URL is parsed for each request.
+		urlStr := r.reqConfigs[i].URLStr
+		if tmpl != nil && tmpl.url != nil {
+			urlStr = ""
+		}
+		req, err := http.NewRequest(r.reqConfigs[i].Method, urlStr, nil)
 		if err != nil {
 			return err
 		}
-		if t.reqConfigs[i].Header != nil {
-			req.Header = t.reqConfigs[i].Header
+		if r.reqConfigs[i].Header != nil {
+			req.Header = r.reqConfigs[i].Header
+		}
+		r.reqConfigs[i].request = req
+	}
+	if r.mix == nil {
+		if err := checkFlow(r.reqConfigs); err != nil {
+			return err
+		}
+	} else {
+		for i := range r.mix.scenarios {
+			first, steps := r.mix.steps(i)
+			if err := checkFlow(r.reqConfigs[first : first+steps]); err != nil {
+				return err
+			}
 		}
-		t.reqConfigs[i].request = req
 	}
 
 	return nil
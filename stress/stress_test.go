@@ -2,10 +2,18 @@ package stress
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -135,6 +143,222 @@ func TestEvents(t *testing.T) {
 	}
 }
 
+func TestTransactionEvents(t *testing.T) {
+	var count int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&count, 1)
+	}))
+	defer ts.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	down.Close()
+
+	var starts, ends, skipped int64
+	eventTask := &Task{Number: 20, Concurrent: 2, ReportHandler: func([]*Result, time.Duration) {}}
+	result, err := eventTask.RunTran(&RequestConfig{
+		URLStr: ts.URL,
+		Method: "GET",
+		Events: &Events{
+			TransactionStart: func(share Share) {
+				atomic.AddInt64(&starts, 1)
+			},
+			TransactionEnd: func(result *Result, share Share) {
+				atomic.AddInt64(&ends, 1)
+				if result.Details[2].Skipped {
+					atomic.AddInt64(&skipped, 1)
+				}
+			},
+		},
+	}, &RequestConfig{
+		URLStr: down.URL,
+		Method: "GET",
+		Events: &Events{OnError: func(req *Request, err error, share Share) {
+			req.Fail(fmt.Errorf("backend down"))
+			if req.Index%2 == 0 {
+				req.Skip()
+			}
+		}},
+	}, &RequestConfig{
+		URLStr: ts.URL,
+		Method: "GET",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := result.Report
+	if starts != 20 || ends != 20 || skipped != 10 || count != 30 {
+		t.Errorf("TestTransactionEvents error, %d starts, %d ends, %d skipped and %d requests", starts, ends, skipped, count)
+	}
+	if report.Errors != 20 || report.Steps[1].Errors["backend down"] != 20 || report.Steps[2].Skipped != 10 ||
+		report.Steps[2].ResponseTime.Count != 10 {
+		t.Errorf("TestTransactionEvents error, steps %+v, %+v", report.Steps[1], report.Steps[2])
+	}
+}
+
+func TestResponseFail(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	var errs int64
+	failTask := &Task{Number: 20, Concurrent: 2, ReportHandler: func([]*Result, time.Duration) {}}
+	result, err := failTask.Run(&RequestConfig{
+		URLStr: ts.URL,
+		Method: "GET",
+		Events: &Events{
+			OnResponse: func(req *Request, res *http.Response, share Share) {
+				if req.Index%2 == 0 {
+					req.Fail(fmt.Errorf("unexpected response"))
+				}
+			},
+			OnError: func(req *Request, err error, share Share) {
+				atomic.AddInt64(&errs, 1)
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := result.Report
+	if errs != 10 || report.Errors != 10 || report.Steps[0].Errors["unexpected response"] != 10 {
+		t.Errorf("TestResponseFail error, %d errors, step %+v", errs, report.Steps[0])
+	}
+}
+
+func TestFlow(t *testing.T) {
+	var mx sync.Mutex
+	paths := make(map[string]int)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mx.Lock()
+		paths[r.URL.Path]++
+		mx.Unlock()
+		if r.URL.Path == "/login" && r.Header.Get("X-Fail") != "" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer ts.Close()
+
+	flowTask := &Task{Number: 20, Concurrent: 2, ReportHandler: func([]*Result, time.Duration) {}}
+	result, err := flowTask.RunTran(&RequestConfig{
+		Name:   "login",
+		URLStr: ts.URL + "/login",
+		Method: "POST",
+		Events: &Events{RequestBefore: func(req *Request, share Share) {
+			if req.Index%2 == 0 {
+				req.Req.Header.Set("X-Fail", "1")
+			}
+		}},
+		Checks:         []*Check{{Type: CheckStatus, Expr: "2xx"}},
+		AbortOnFailure: true,
+	}, &RequestConfig{
+		URLStr: ts.URL + "/poll",
+		Method: "GET",
+		Repeat: 5,
+		Until: func(share Share) bool {
+			n, _ := share["polls"].(int)
+			share["polls"] = n + 1
+			return n+1 == 3
+		},
+	}, &RequestConfig{
+		URLStr: ts.URL + "/coupon",
+		Method: "GET",
+		Skip: func(share Share) bool {
+			return share["coupon"] == nil
+		},
+	}, &RequestConfig{
+		URLStr: ts.URL + "/cart",
+		Method: "GET",
+		Next: func(share Share) string {
+			if share["carts"] == nil {
+				share["carts"] = 1
+				return "cart"
+			}
+			return "checkout"
+		},
+		Name: "cart",
+	}, &RequestConfig{
+		URLStr: ts.URL + "/unreachable",
+		Method: "GET",
+	}, &RequestConfig{
+		Name:   "checkout",
+		URLStr: ts.URL + "/checkout",
+		Method: "POST",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := result.Report
+	if paths["/login"] != 20 || paths["/poll"] != 30 || paths["/coupon"] != 0 || paths["/cart"] != 20 ||
+		paths["/unreachable"] != 0 || paths["/checkout"] != 10 {
+		t.Errorf("TestFlow error, requests %v", paths)
+	}
+	if report.Transactions != 20 || report.AbortedTransactions != 10 || report.Steps[0].AbortReasons[AbortFailure] != 10 ||
+		report.Steps[1].ResponseTime.Count != 30 || report.Steps[2].Skipped != 20 || report.Steps[5].Skipped != 10 || report.Steps[5].ResponseTime.Count != 10 {
+		t.Errorf("TestFlow error, report %+v, steps %+v", report, report.Steps)
+	}
+
+	_, err = flowTask.RunTran(&RequestConfig{Name: "a", URLStr: ts.URL, Method: "GET"}, &RequestConfig{Name: "a", URLStr: ts.URL, Method: "GET"})
+	if err == nil {
+		t.Errorf("TestFlow error, duplicate names passed")
+	}
+
+	// A jump to an unknown step aborts the transaction without failing the request.
+	result, err = flowTask.RunTran(&RequestConfig{
+		URLStr: ts.URL + "/cart",
+		Method: "GET",
+		Next: func(share Share) string {
+			return "missing"
+		},
+	}, &RequestConfig{
+		URLStr: ts.URL + "/checkout",
+		Method: "POST",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report = result.Report
+	if report.Errors != 0 || report.AbortedTransactions != 20 || report.Steps[0].AbortReasons[`no step named "missing"`] != 20 {
+		t.Errorf("TestFlow error, unknown step, report %+v, step %+v", report, report.Steps[0])
+	}
+
+	// The endless repeats and loops are aborted at the end of the Duration.
+	for _, config := range []*RequestConfig{
+		{URLStr: ts.URL + "/poll", Method: "GET", Until: func(Share) bool { return false }},
+		{Name: "a", URLStr: ts.URL + "/poll", Method: "GET", Next: func(Share) string { return "a" }},
+	} {
+		durationTask := &Task{Duration: 300 * time.Millisecond, Concurrent: 2}
+		start := time.Now()
+		result, err = durationTask.RunTran(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		report = result.Report
+		if end := time.Now().Sub(start); end > 2*time.Second || report.Steps[0].AbortReasons[AbortStopped] != 2 {
+			t.Errorf("TestFlow error, endless flow ran for %v, step %+v", end, report.Steps[0])
+		}
+	}
+
+	// Until without Repeat repeats the request until it returns true.
+	paths = make(map[string]int)
+	result, err = flowTask.RunTran(&RequestConfig{
+		URLStr: ts.URL + "/poll",
+		Method: "GET",
+		Until: func(share Share) bool {
+			n, _ := share["polls"].(int)
+			share["polls"] = n + 1
+			return n+1 == 7
+		},
+	}, &RequestConfig{
+		URLStr: ts.URL + "/checkout",
+		Method: "POST",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report = result.Report
+	if paths["/poll"] != 140 || paths["/checkout"] != 20 || report.AbortedTransactions != 0 {
+		t.Errorf("TestFlow error, Until without Repeat, requests %v", paths)
+	}
+}
+
 func TestTran(t *testing.T) {
 	var count1, count2 int64
 	ts1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -177,4 +401,1089 @@ func TestTran(t *testing.T) {
 	if count1 != 100 || count2 != 100 {
 		t.Error("TestTran error")
 	}
+
+	if _, err := task.RunTran(); err == nil {
+		t.Errorf("TestTran error, a transaction without requests passed")
+	}
+	if _, err := task.Collect(context.Background(), make(chan *Result)); err == nil {
+		t.Errorf("TestTran error, a collect without requests passed")
+	}
+}
+
+func TestExtractors(t *testing.T) {
+	var count int64
+	ts1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s1"})
+		w.Header().Set("X-Request-Id", "r1")
+		fmt.Fprintf(w, `{"data": {"token": "t1", "items": [{"id": 7}]}}`)
+	}))
+	defer ts1.Close()
+	ts2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts2.Close()
+
+	var mx sync.Mutex
+	var shares []Share
+	extractTask := &Task{
+		Number:     10,
+		Concurrent: 2,
+	}
+	result, err := extractTask.RunTran(&RequestConfig{
+		URLStr: ts1.URL,
+		Method: "GET",
+		Extractors: []*Extractor{
+			{Name: "token", Source: ExtractJSON, Expr: "$.data.token"},
+			{Name: "id", Source: ExtractJSON, Expr: "$.data.items[0].id"},
+			{Name: "requestId", Source: ExtractHeader, Expr: "x-request-id"},
+			{Name: "session", Source: ExtractCookie, Expr: "session"},
+			{Name: "quoted", Source: ExtractRegexp, Expr: `"token": "(\w+)"`},
+			{Name: "missing", Source: ExtractJSON, Expr: "$.data.missing", Default: "none"},
+		},
+		Events: &Events{
+			ResponseAfter: func(res *http.Response, share Share) {
+				if body, _ := ioutil.ReadAll(res.Body); len(body) > 0 {
+					atomic.AddInt64(&count, 1)
+				}
+			},
+		},
+	}, &RequestConfig{
+		URLStr: ts2.URL,
+		Method: "GET",
+		Events: &Events{
+			RequestBefore: func(reqInfo *Request, share Share) {
+				mx.Lock()
+				shares = append(shares, share)
+				mx.Unlock()
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := result.Report
+	if report.Errors != 0 || count != 10 || len(shares) != 10 {
+		t.Fatalf("TestExtractors error, %d errors, %d bodies, %d shares", report.Errors, count, len(shares))
+	}
+	want := Share{"token": "t1", "id": "7", "requestId": "r1", "session": "s1", "quoted": "t1", "missing": "none"}
+	for k, v := range want {
+		if shares[0][k] != v {
+			t.Errorf("TestExtractors error, %s = %v", k, shares[0][k])
+		}
+	}
+
+	failTask := &Task{
+		Number:     10,
+		Concurrent: 2,
+	}
+	result, err = failTask.Run(&RequestConfig{
+		URLStr:     ts1.URL,
+		Method:     "GET",
+		Extractors: []*Extractor{{Name: "missing", Source: ExtractRegexp, Expr: "nothing"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report = result.Report
+	if report.Errors != 10 || len(report.Steps[0].Errors) != 1 {
+		t.Errorf("TestExtractors error, %d errors %v", report.Errors, report.Steps[0].Errors)
+	}
+
+	_, err = failTask.Run(&RequestConfig{
+		URLStr:     ts1.URL,
+		Method:     "GET",
+		Extractors: []*Extractor{{Name: "token", Source: "xml", Expr: "/token"}},
+	})
+	if err == nil {
+		t.Errorf("TestExtractors error, an invalid source passed")
+	}
+}
+
+func TestCookies(t *testing.T) {
+	var sessions, sent int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie("session"); err == nil {
+			atomic.AddInt64(&sent, 1)
+			return
+		}
+		n := atomic.AddInt64(&sessions, 1)
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: fmt.Sprint(n)})
+	}))
+	defer ts.Close()
+
+	for _, c := range []struct {
+		cookies        string
+		sessions, sent int64
+	}{{"", 40, 0}, {CookiesTransaction, 20, 20}, {CookiesUser, 2, 38}} {
+		sessions, sent = 0, 0
+		cookieTask := &Task{Number: 20, Concurrent: 2, Cookies: c.cookies, ReportHandler: func([]*Result, time.Duration) {}}
+		_, err := cookieTask.RunTran(
+			&RequestConfig{URLStr: ts.URL + "/login", Method: "POST"},
+			&RequestConfig{URLStr: ts.URL + "/me", Method: "GET"},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sessions != c.sessions || sent != c.sent {
+			t.Errorf("TestCookies error, cookies %q: %d sessions and %d requests with the cookie", c.cookies, sessions, sent)
+		}
+	}
+
+	// The cookies of the user are reset in each transaction, after the login.
+	sessions, sent = 0, 0
+	cookieTask := &Task{Number: 20, Concurrent: 2, Cookies: CookiesUser, ReportHandler: func([]*Result, time.Duration) {}}
+	_, err := cookieTask.RunTran(
+		&RequestConfig{URLStr: ts.URL + "/login", Method: "POST"},
+		&RequestConfig{URLStr: ts.URL + "/me", Method: "GET", Events: &Events{
+			RequestBefore: func(req *Request, share Share) {
+				req.VU.ResetCookies()
+			},
+		}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sessions != 20 || sent != 20 {
+		t.Errorf("TestCookies error, reset cookies: %d sessions and %d requests with the cookie", sessions, sent)
+	}
+
+	if _, err := (&Task{Number: 1, Concurrent: 1, Cookies: "browser"}).Run(&RequestConfig{URLStr: ts.URL, Method: "GET"}); err == nil {
+		t.Errorf("TestCookies error, an invalid Cookies passed")
+	}
+}
+
+func TestHooks(t *testing.T) {
+	var mx sync.Mutex
+	tokens := make(map[string]int)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mx.Lock()
+		tokens[r.Header.Get("Authorization")]++
+		mx.Unlock()
+	}))
+	defer ts.Close()
+
+	var starts, stops, iterations, teardowns int64
+	hookTask := &Task{
+		Number:     20,
+		Concurrent: 4,
+		Setup: func() (interface{}, error) {
+			return "token", nil
+		},
+		Teardown: func(data interface{}) {
+			if data == "token" {
+				atomic.AddInt64(&teardowns, 1)
+			}
+		},
+		OnStart: func(vu *VU) {
+			atomic.AddInt64(&starts, 1)
+			vu.Store["token"] = fmt.Sprint(vu.Data, vu.No)
+			vu.Store["iterations"] = 0
+		},
+		OnStop: func(vu *VU) {
+			atomic.AddInt64(&stops, 1)
+			atomic.AddInt64(&iterations, int64(vu.Store["iterations"].(int)))
+		},
+		ReportHandler: func([]*Result, time.Duration) {},
+	}
+	_, err := hookTask.Run(&RequestConfig{
+		URLStr: ts.URL,
+		Method: "GET",
+		Header: http.Header{"Authorization": {"{{.VU.Store.token}}"}},
+		Events: &Events{RequestBefore: func(req *Request, share Share) {
+			req.VU.Store["iterations"] = req.VU.Store["iterations"].(int) + 1
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if starts != 4 || stops != 4 || iterations != 20 || teardowns != 1 {
+		t.Errorf("TestHooks error, %d starts, %d stops, %d iterations and %d teardowns", starts, stops, iterations, teardowns)
+	}
+	if len(tokens) != 4 || tokens["token0"] != 5 || tokens["token3"] != 5 {
+		t.Errorf("TestHooks error, tokens %v", tokens)
+	}
+
+	hookTask.Setup = func() (interface{}, error) {
+		return nil, fmt.Errorf("setup failed")
+	}
+	if _, err := hookTask.Run(&RequestConfig{URLStr: ts.URL, Method: "GET"}); err == nil || len(tokens) != 4 {
+		t.Errorf("TestHooks error, a task with a failed Setup was run")
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	var mx sync.Mutex
+	seen := make(map[string]bool)
+	var failed int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			fmt.Fprintf(w, `{"token": "t1"}`)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		key := r.Header.Get("Idempotency-Key")
+		if r.URL.Query().Get("token") != "t1" || r.Header.Get("Authorization") != "Bearer t1" ||
+			!strings.HasPrefix(string(body), "n=") || len(key) != 36 {
+			atomic.AddInt64(&failed, 1)
+		}
+		mx.Lock()
+		seen[key] = true
+		mx.Unlock()
+	}))
+	defer ts.Close()
+
+	h := make(http.Header)
+	h.Set("Authorization", "Bearer {{.Share.token}}")
+	h.Set("Idempotency-Key", "{{uuid}}")
+	templateTask := &Task{
+		Number:     20,
+		Concurrent: 2,
+	}
+	result, err := templateTask.RunTran(&RequestConfig{
+		URLStr:     ts.URL + "/login",
+		Method:     "POST",
+		Extractors: []*Extractor{{Name: "token", Source: ExtractJSON, Expr: "$.token"}},
+	}, &RequestConfig{
+		URLStr:  ts.URL + "/orders/{{.GoRoutineNo}}-{{.Index}}?token={{.Share.token}}&t={{timestamp}}",
+		Method:  "POST",
+		Header:  h,
+		ReqBody: []byte("n={{randInt 1 10}}&s={{randString 8}}&at={{now \"2006-01-02\"}}"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := result.Report
+	if report.Errors != 0 || failed != 0 || len(seen) != 20 {
+		t.Errorf("TestTemplates error, %d errors, %d failed, %d keys", report.Errors, failed, len(seen))
+	}
+
+	result, err = templateTask.Run(&RequestConfig{
+		URLStr: ts.URL + "/orders?token={{.Share.token}}",
+		Method: "GET",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report = result.Report
+	if report.Errors != 20 {
+		t.Errorf("TestTemplates error, %d errors for a missing share value", report.Errors)
+	}
+
+	_, err = templateTask.Run(&RequestConfig{
+		URLStr: ts.URL + "/{{.Index",
+		Method: "GET",
+	})
+	if err == nil {
+		t.Errorf("TestTemplates error, an invalid template passed")
+	}
+}
+
+func TestFeeder(t *testing.T) {
+	var mx sync.Mutex
+	seen := make(map[string]int)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mx.Lock()
+		seen[r.URL.Query().Get("user")]++
+		mx.Unlock()
+	}))
+	defer ts.Close()
+
+	var rows []map[string]interface{}
+	for i := 0; i < 30; i++ {
+		rows = append(rows, map[string]interface{}{"user": fmt.Sprint(i)})
+	}
+	feederTask := &Task{
+		Duration:   10 * time.Second,
+		Concurrent: 3,
+		Feeder:     &Feeder{Rows: rows, Mode: FeedOnce},
+	}
+	result, err := feederTask.Run(&RequestConfig{
+		URLStr: ts.URL + "?user={{.Share.user}}",
+		Method: "GET",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := result.Report
+	if report.Transactions != 30 || report.Total > 5 || len(seen) != 30 {
+		t.Errorf("TestFeeder error, %d transactions in %v secs, %d users", report.Transactions, report.Total, len(seen))
+	}
+	for user, n := range seen {
+		if n != 1 {
+			t.Errorf("TestFeeder error, user %s sent %d times", user, n)
+		}
+	}
+
+	seen = make(map[string]int)
+	feederTask = &Task{
+		Number:     60,
+		Concurrent: 3,
+		Rate:       1000,
+		Feeder:     &Feeder{Rows: rows},
+	}
+	result, err = feederTask.Run(&RequestConfig{
+		URLStr: ts.URL + "?user={{.Share.user}}",
+		Method: "GET",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report = result.Report
+	if report.Transactions != 60 || len(seen) != 30 {
+		t.Errorf("TestFeeder error, %d transactions, %d users", report.Transactions, len(seen))
+	}
+}
+
+func TestChecks(t *testing.T) {
+	var count int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&count, 1)%4 == 0 {
+			fmt.Fprintf(w, `{"code": 1, "message": "error"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"code": 0, "message": "ok"}`)
+	}))
+	defer ts.Close()
+
+	checkTask := &Task{
+		Number:     20,
+		Concurrent: 1,
+	}
+	result, err := checkTask.Run(&RequestConfig{
+		URLStr: ts.URL,
+		Method: "GET",
+		Checks: []*Check{
+			{Type: CheckStatus, Expr: "2xx"},
+			{Name: "code", Type: CheckJSON, Expr: "$.code", Value: "0"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := result.Report
+	step := report.Steps[0]
+	if report.Errors != 5 || step.CheckFailures != 5 || len(step.Errors) != 0 || step.StatusCodes[200] != 20 {
+		t.Errorf("TestChecks error, %d errors, %d check failures, %v, %v", report.Errors, step.CheckFailures, step.Errors, step.StatusCodes)
+	}
+	if len(step.Checks) != 2 || step.Checks[0].Name != "status 2xx" || step.Checks[0].Passed != 20 ||
+		step.Checks[1].Name != "code" || step.Checks[1].Passed != 15 || step.Checks[1].Failed != 5 {
+		t.Errorf("TestChecks error, checks %+v %+v", step.Checks[0], step.Checks[1])
+	}
+}
+
+func TestRunThresholds(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	thresholdTask := &Task{
+		Number:     10,
+		Concurrent: 2,
+		Thresholds: []string{"p99<10s", "errors<1%"},
+	}
+	_, err := thresholdTask.RunTran(&RequestConfig{
+		URLStr:     ts.URL,
+		Method:     "GET",
+		Thresholds: []string{"count==10"},
+	}, &RequestConfig{
+		URLStr:     ts.URL + "/fail",
+		Method:     "GET",
+		Checks:     []*Check{{Type: CheckStatus, Expr: "2xx"}},
+		Thresholds: []string{"errors<=10"},
+	})
+	if err == nil {
+		t.Fatalf("TestRunThresholds error, an invalid threshold passed")
+	}
+	result, err := thresholdTask.RunTran(&RequestConfig{
+		URLStr:     ts.URL,
+		Method:     "GET",
+		Thresholds: []string{"count>=10"},
+	}, &RequestConfig{
+		URLStr:     ts.URL + "/fail",
+		Method:     "GET",
+		Checks:     []*Check{{Type: CheckStatus, Expr: "2xx"}},
+		Thresholds: []string{"errors<=10"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := result.Report
+	if len(report.Thresholds) != 4 || report.Passed() {
+		t.Fatalf("TestRunThresholds error, %d thresholds, passed %v", len(report.Thresholds), report.Passed())
+	}
+	for i, want := range []bool{true, false, true, true} {
+		if report.Thresholds[i].Passed != want {
+			t.Errorf("TestRunThresholds error, %+v", report.Thresholds[i])
+		}
+	}
+	if report.Thresholds[1].Value != 100 || report.Thresholds[2].Step != 1 || report.Thresholds[3].Step != 2 {
+		t.Errorf("TestRunThresholds error, %+v %+v %+v", report.Thresholds[1], report.Thresholds[2], report.Thresholds[3])
+	}
+
+	// A latency threshold of a request that always fails has no latency and fails.
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	down.Close()
+	thresholdTask.Thresholds = nil
+	result, err = thresholdTask.Run(&RequestConfig{
+		URLStr:     down.URL,
+		Method:     "GET",
+		Thresholds: []string{"p99<300ms", "avg<300ms"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report = result.Report
+	if len(report.Thresholds) != 2 || report.Passed() || !report.Thresholds[0].NoData || !report.Thresholds[1].NoData {
+		t.Errorf("TestRunThresholds error, thresholds of a failed request %+v %+v", report.Thresholds[0], report.Thresholds[1])
+	}
+}
+
+func TestAbort(t *testing.T) {
+	var count int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&count, 1) > 20 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	abortTask := &Task{
+		Duration:   10 * time.Second,
+		Concurrent: 2,
+		Abort:      &Abort{ConsecutiveFailures: 10},
+	}
+	result, err := abortTask.Run(&RequestConfig{
+		URLStr: ts.URL,
+		Method: "GET",
+		Checks: []*Check{{Type: CheckStatus, Expr: "2xx"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := result.Report
+	if report.Aborted != "10 consecutive failed transactions" || report.Total > 5 || report.Errors < 10 || report.Errors > 12 {
+		t.Errorf("TestAbort error, aborted %q after %v secs with %d errors", report.Aborted, report.Total, report.Errors)
+	}
+
+	count = 0
+	abortTask.Abort = &Abort{Window: 500 * time.Millisecond, ErrorRate: 0.5}
+	result, err = abortTask.Run(&RequestConfig{
+		URLStr: ts.URL,
+		Method: "GET",
+		Checks: []*Check{{Type: CheckStatus, Expr: "2xx"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report = result.Report
+	if !strings.HasPrefix(report.Aborted, "error rate") || report.Total > 5 {
+		t.Errorf("TestAbort error, aborted %q after %v secs", report.Aborted, report.Total)
+	}
+
+	abortTask.Abort = &Abort{ErrorRate: 2}
+	if _, err := abortTask.Run(&RequestConfig{URLStr: ts.URL, Method: "GET"}); err == nil {
+		t.Errorf("TestAbort error, an invalid error rate passed")
+	}
+	abortTask.Abort = &Abort{Window: time.Nanosecond, ErrorRate: 0.5}
+	if _, err := abortTask.Run(&RequestConfig{URLStr: ts.URL, Method: "GET"}); err == nil {
+		t.Errorf("TestAbort error, a too small window passed")
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	var count int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Hang after the first transactions until the request is canceled.
+		if atomic.AddInt64(&count, 1) > 5 {
+			<-r.Context().Done()
+		}
+	}))
+	defer ts.Close()
+
+	ctxTask := &Task{
+		Duration:   10 * time.Second,
+		Concurrent: 1,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	result, err := ctxTask.RunContext(ctx, &RequestConfig{
+		URLStr: ts.URL,
+		Method: "GET",
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("TestRunContext error, returned %v", err)
+	}
+	if elapsed := time.Now().Sub(start); elapsed > 2*time.Second {
+		t.Errorf("TestRunContext error, returned after %v", elapsed)
+	}
+	if result == nil || result.Report.Transactions != 5 || result.Report.Errors != 0 {
+		t.Errorf("TestRunContext error, partial result %+v", result)
+	}
+}
+
+func TestRunConcurrent(t *testing.T) {
+	var count int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&count, 1)
+	}))
+	defer ts.Close()
+
+	runTask := &Task{
+		Number:      20,
+		Concurrent:  2,
+		Timeout:     5,
+		KeepResults: true,
+		Feeder:      &Feeder{Rows: []map[string]interface{}{{"id": "1"}, {"id": "2"}}, Mode: FeedOnce},
+	}
+	config := &RequestConfig{
+		URLStr: ts.URL + "/{{.Share.id}}",
+		Method: "get",
+		Checks: []*Check{{Type: CheckStatus, Expr: "2xx"}},
+	}
+	var wg sync.WaitGroup
+	results := make([]*RunResult, 3)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = runTask.Run(config)
+		}(i)
+	}
+	wg.Wait()
+	for _, result := range results {
+		if result == nil || result.Report.Transactions != 2 || len(result.Results) != 2 || len(result.Errors) != 0 {
+			t.Fatalf("TestRunConcurrent error, result %+v", result)
+		}
+	}
+	if count != 6 {
+		t.Errorf("TestRunConcurrent error, sent %d requests", count)
+	}
+	if config.Method != "get" || config.Timeout != 0 || config.Checks[0].Name != "" {
+		t.Errorf("TestRunConcurrent error, config modified %+v", config)
+	}
+}
+
+func TestMix(t *testing.T) {
+	var browse, search, checkout int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/browse":
+			atomic.AddInt64(&browse, 1)
+		case "/search":
+			atomic.AddInt64(&search, 1)
+		case "/checkout":
+			atomic.AddInt64(&checkout, 1)
+		}
+	}))
+	defer ts.Close()
+
+	mixTask := &Task{
+		Number:     400,
+		Concurrent: 4,
+	}
+	result, err := mixTask.RunMix(&Scenario{
+		Name:    "browse",
+		Weight:  3,
+		Configs: []*RequestConfig{{URLStr: ts.URL + "/browse", Method: "GET"}},
+	}, &Scenario{
+		Name: "checkout",
+		Configs: []*RequestConfig{
+			{URLStr: ts.URL + "/search", Method: "GET"},
+			{URLStr: ts.URL + "/checkout", Method: "POST"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := result.Report
+	if browse+search != 400 || search != checkout || browse < 240 || browse > 360 {
+		t.Errorf("TestMix error, %d browse, %d search and %d checkout requests", browse, search, checkout)
+	}
+	if len(report.Scenarios) != 2 || report.Scenarios[0].Transactions != browse || report.Scenarios[1].Transactions != checkout ||
+		report.Scenarios[1].Weight != 1 {
+		t.Fatalf("TestMix error, scenarios %+v", report.Scenarios)
+	}
+	if len(report.Steps) != 3 || report.Steps[0].Scenario != "browse" || report.Steps[2].Scenario != "checkout" ||
+		report.Steps[0].ResponseTime.Count != browse || report.Steps[2].ResponseTime.Count != checkout {
+		t.Errorf("TestMix error, steps %+v", report.Steps)
+	}
+
+	if _, err := mixTask.RunMix(&Scenario{Name: "empty"}); err == nil {
+		t.Errorf("TestMix error, a scenario without requests passed")
+	}
+}
+
+func TestCollect(t *testing.T) {
+	results := make(chan *Result)
+	collectTask := &Task{Number: -1, Concurrent: 1}
+	done := make(chan *RunResult)
+	go func() {
+		result, err := collectTask.Collect(context.Background(), results, &RequestConfig{URLStr: "http://127.0.0.1/a", Method: "GET"})
+		if err != nil {
+			t.Error(err)
+		}
+		done <- result
+	}()
+	// The results start after the start of the task, whenever the task starts.
+	start := time.Now().Add(time.Second)
+	for i := 0; i < 10; i++ {
+		res := &ResultDetail{URLStr: "http://127.0.0.1/a", Method: "GET", Start: start, StatusCode: 200, Duration: time.Millisecond}
+		if i%5 == 0 {
+			res.StatusCode, res.Err = 0, fmt.Errorf("failed")
+		}
+		results <- &Result{Start: res.Start, Duration: res.Duration, Details: []*ResultDetail{res}}
+	}
+	// The results of another task, or started before the task, are dropped.
+	results <- &Result{Start: start, Details: []*ResultDetail{{}, {Step: 1}}}
+	results <- &Result{Start: start, Details: []*ResultDetail{{Checks: []bool{true}}}}
+	results <- &Result{Details: []*ResultDetail{{}}}
+	results <- &Result{Start: time.Now().Add(-time.Hour), Details: []*ResultDetail{{}}}
+	close(results)
+	result := <-done
+	if result.Report.Transactions != 10 || result.Report.Errors != 2 || result.Errors["failed"] != 2 {
+		t.Errorf("TestCollect error, report %+v, errors %v", result.Report, result.Errors)
+	}
+}
+
+func TestRate(t *testing.T) {
+	var count int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&count, int64(1))
+	}))
+	defer ts.Close()
+
+	rateTask := &Task{
+		Number:        30,
+		Concurrent:    4,
+		Rate:          100,
+		ReportHandler: func(results []*Result, totalTime time.Duration) {},
+	}
+	start := time.Now()
+	rateTask.Run(&RequestConfig{
+		URLStr: ts.URL,
+		Method: "GET",
+	})
+	end := time.Now().Sub(start)
+	if count != 30 {
+		t.Errorf("TestRate error, sent %d requests", count)
+	}
+	if end < 290*time.Millisecond {
+		t.Errorf("TestRate error, finished too early: %v", end)
+	}
+
+	// The starts that are late at the end of the Duration are missed.
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer slow.Close()
+	rateTask = &Task{
+		Duration:      500 * time.Millisecond,
+		Concurrent:    1,
+		Rate:          50,
+		ReportHandler: func(results []*Result, totalTime time.Duration) {},
+	}
+	start = time.Now()
+	result, err := rateTask.Run(&RequestConfig{
+		URLStr: slow.URL,
+		Method: "GET",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	end = time.Now().Sub(start)
+	report := result.Report
+	if end > time.Second || report.Transactions > 7 || report.Transactions+report.MissedStarts != 25 {
+		t.Errorf("TestRate error, ran for %v with %d transactions and %d missed starts", end, report.Transactions, report.MissedStarts)
+	}
+}
+
+func TestStages(t *testing.T) {
+	var inflight, maxInflight int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&inflight, 1)
+		for {
+			max := atomic.LoadInt64(&maxInflight)
+			if n <= max || atomic.CompareAndSwapInt64(&maxInflight, max, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt64(&inflight, -1)
+	}))
+	defer ts.Close()
+
+	var stageCounts [3]int
+	stagesTask := &Task{
+		Stages: []Stage{
+			{Duration: 300 * time.Millisecond, Concurrent: 5},
+			{Duration: 300 * time.Millisecond, Concurrent: 5},
+			{Duration: 300 * time.Millisecond, Concurrent: 0},
+		},
+		ReportHandler: func(results []*Result, totalTime time.Duration) {
+			for _, result := range results {
+				stageCounts[result.Stage]++
+			}
+		},
+	}
+	start := time.Now()
+	result, err := stagesTask.Run(&RequestConfig{
+		URLStr: ts.URL,
+		Method: "GET",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stages := result.Report.Config.Stages; len(stages) != 3 || stages[0].Duration != 0.3 || stages[1].Concurrent != 5 {
+		t.Errorf("TestStages error, config stages %+v", stages)
+	}
+	end := time.Now().Sub(start)
+	if end < 900*time.Millisecond || end > 1500*time.Millisecond {
+		t.Errorf("TestStages error, run took %v", end)
+	}
+	if maxInflight != 5 {
+		t.Errorf("TestStages error, max concurrency %d", maxInflight)
+	}
+	if stageCounts[0] == 0 || stageCounts[1] <= stageCounts[0] {
+		t.Errorf("TestStages error, transactions per stage %v", stageCounts)
+	}
+}
+
+func TestStagesRate(t *testing.T) {
+	var count int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&count, 1)
+	}))
+	defer ts.Close()
+
+	stagesTask := &Task{
+		Concurrent: 5,
+		Stages: []Stage{
+			{Duration: 500 * time.Millisecond, Rate: 100},
+			{Duration: 500 * time.Millisecond, Rate: 100},
+		},
+		ReportHandler: func(results []*Result, totalTime time.Duration) {},
+	}
+	_, err := stagesTask.Run(&RequestConfig{
+		URLStr: ts.URL,
+		Method: "GET",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A linear ramp from 0 to 100/s over 0.5s then 0.5s at 100/s.
+	if count < 65 || count > 85 {
+		t.Errorf("TestStagesRate error, sent %d requests", count)
+	}
+}
+
+func TestCorrectedDuration(t *testing.T) {
+	var count int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&count, 1) == 1 {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}))
+	defer ts.Close()
+
+	var omitted int
+	rateTask := &Task{
+		Number:     10,
+		Concurrent: 1,
+		Rate:       100,
+		ReportHandler: func(results []*Result, totalTime time.Duration) {
+			for _, result := range results {
+				if result.CorrectedDuration < result.Duration {
+					t.Errorf("TestCorrectedDuration error, corrected %v < %v", result.CorrectedDuration, result.Duration)
+				}
+				if result.CorrectedDuration-result.Duration > 50*time.Millisecond {
+					omitted++
+				}
+			}
+		},
+	}
+	rateTask.Run(&RequestConfig{
+		URLStr: ts.URL,
+		Method: "GET",
+	})
+	// The requests scheduled during the slow first response waited to be sent.
+	if omitted < 5 {
+		t.Errorf("TestCorrectedDuration error, %d delayed requests", omitted)
+	}
+}
+
+func TestReport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			w.WriteHeader(http.StatusCreated)
+		}
+		fmt.Fprintf(w, "hello")
+	}))
+	defer ts.Close()
+
+	reportTask := &Task{
+		Number:      20,
+		Concurrent:  2,
+		Format:      FormatJSON,
+		Percentiles: []float64{50, 99},
+		Thresholds:  []string{"p99<10s"},
+		Abort:       &Abort{ErrorRate: 0.9},
+		Cookies:     CookiesUser,
+	}
+	result, err := reportTask.RunTran(&RequestConfig{
+		URLStr:     ts.URL,
+		Method:     "GET",
+		Thresholds: []string{"errors<50%"},
+	}, &RequestConfig{
+		URLStr: ts.URL,
+		Method: "POST",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := result.Report
+	if report.Transactions != 20 || report.Errors != 0 || len(report.Steps) != 2 {
+		t.Fatalf("TestReport error, %d transactions, %d errors, %d steps", report.Transactions, report.Errors, len(report.Steps))
+	}
+	if report.Steps[0].StatusCodes[200] != 20 || report.Steps[1].StatusCodes[201] != 20 {
+		t.Errorf("TestReport error, status codes %v %v", report.Steps[0].StatusCodes, report.Steps[1].StatusCodes)
+	}
+	if report.Steps[1].TotalData != 100 || len(report.Latency.Percentiles) != 2 || report.Config.Requests[1].Method != "POST" {
+		t.Errorf("TestReport error, report %+v", report)
+	}
+	config := report.Config
+	if len(config.Thresholds) != 1 || len(config.Requests[0].Thresholds) != 1 || config.Cookies != CookiesUser ||
+		config.Abort == nil || config.Abort.Window != 10 || config.Abort.MinTransactions != 10 || config.Abort.ErrorRate != 0.9 {
+		t.Errorf("TestReport error, config %+v, abort %+v", config, config.Abort)
+	}
+	if _, err := json.Marshal(report); err != nil {
+		t.Errorf("TestReport error, %v", err)
+	}
+}
+
+func TestOutput(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello")
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "stress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	outputTask := &Task{
+		Number:     20,
+		Concurrent: 2,
+		Output:     dir,
+	}
+	_, err = outputTask.Run(&RequestConfig{
+		URLStr: ts.URL,
+		Method: "GET",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"report.txt", "index.html"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("TestOutput error, %v", err)
+		}
+	}
+	html, _ := ioutil.ReadFile(filepath.Join(dir, "index.html"))
+	if !strings.Contains(string(html), "<svg") || !strings.Contains(string(html), ts.URL) {
+		t.Errorf("TestOutput error, index.html without charts")
+	}
+
+	// A quiet task does not write its report.
+	quietDir, err := ioutil.TempDir("", "stress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(quietDir)
+	outputTask = &Task{Number: 20, Concurrent: 2, Output: quietDir, Quiet: true}
+	result, err := outputTask.Run(&RequestConfig{URLStr: ts.URL, Method: "GET"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(quietDir, "report.txt")); err == nil || result.Report.Transactions != 20 {
+		t.Errorf("TestOutput error, a quiet task wrote its report")
+	}
+
+	// The files are not created if the task fails to start.
+	failDir, err := ioutil.TempDir("", "stress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(failDir)
+	outputTask = &Task{
+		Number:     20,
+		Concurrent: 2,
+		Output:     failDir,
+		Setup: func() (interface{}, error) {
+			return nil, fmt.Errorf("no token")
+		},
+	}
+	if _, err := outputTask.Run(&RequestConfig{URLStr: ts.URL, Method: "GET"}); err == nil {
+		t.Errorf("TestOutput error, a failed Setup passed")
+	}
+	if files, _ := ioutil.ReadDir(failDir); len(files) != 0 {
+		t.Errorf("TestOutput error, %d files created by a failed task", len(files))
+	}
+}
+
+func TestTimeSeries(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond)
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "stress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	seriesTask := &Task{
+		Duration:   time.Second,
+		Concurrent: 2,
+		Interval:   200 * time.Millisecond,
+		Output:     dir,
+	}
+	result, err := seriesTask.RunTran(&RequestConfig{
+		URLStr: ts.URL,
+		Method: "GET",
+	}, &RequestConfig{
+		URLStr: ts.URL,
+		Method: "POST",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := result.Report
+	if len(report.Timeline) < 5 || len(report.Timeline) > 6 {
+		t.Fatalf("TestTimeSeries error, %d intervals", len(report.Timeline))
+	}
+	var transactions, requests int64
+	for i, point := range report.Timeline {
+		if math.Abs(point.Start-float64(i)*0.2) > 1e-9 {
+			t.Errorf("TestTimeSeries error, interval %d starts at %v", i, point.Start)
+		}
+		if len(point.Steps) != 2 {
+			t.Errorf("TestTimeSeries error, interval %d steps %+v", i, point.Steps)
+		}
+		transactions += point.Transactions
+		requests += point.Steps[0].Requests
+	}
+	if transactions != report.Transactions || requests != report.Transactions {
+		t.Errorf("TestTimeSeries error, %d transactions and %d requests in the timeline, %d in the report", transactions, requests, report.Transactions)
+	}
+	csv, err := ioutil.ReadFile(filepath.Join(dir, "timeseries.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(csv)), "\n")
+	if len(lines) != 1+3*len(report.Timeline) || !strings.HasPrefix(lines[0], "start,step,") {
+		t.Errorf("TestTimeSeries error, timeseries.csv:\n%s", csv)
+	}
+
+	// A request is in the interval it started in, after a slow step.
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			time.Sleep(250 * time.Millisecond)
+		}
+	}))
+	defer slow.Close()
+	seriesTask = &Task{
+		Number:        1,
+		Concurrent:    1,
+		Interval:      100 * time.Millisecond,
+		ReportHandler: func(results []*Result, totalTime time.Duration) {},
+	}
+	result, err = seriesTask.RunTran(&RequestConfig{
+		URLStr: slow.URL,
+		Method: "GET",
+	}, &RequestConfig{
+		URLStr: slow.URL,
+		Method: "POST",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeline := result.Report.Timeline
+	if len(timeline) != 3 || timeline[0].Steps[0].Requests != 1 || timeline[0].Steps[1].Requests != 0 || timeline[2].Steps[1].Requests != 1 {
+		t.Errorf("TestTimeSeries error, timeline of a slow step %+v", timeline)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond)
+	}))
+	defer ts.Close()
+
+	var snapshots []Snapshot
+	progressTask := &Task{
+		Duration:      1500 * time.Millisecond,
+		Concurrent:    2,
+		ReportHandler: func(results []*Result, totalTime time.Duration) {},
+		OnProgress: func(snapshot Snapshot) {
+			snapshots = append(snapshots, snapshot)
+		},
+	}
+	progressTask.Run(&RequestConfig{
+		URLStr: ts.URL,
+		Method: "GET",
+	})
+	if len(snapshots) != 1 {
+		t.Fatalf("TestProgress error, %d snapshots", len(snapshots))
+	}
+	s := snapshots[0]
+	if s.Transactions == 0 || s.RPS == 0 || s.P99 < s.P50 || s.P50 < time.Millisecond || s.InFlight > 2 {
+		t.Errorf("TestProgress error, snapshot %+v", s)
+	}
+	if s.Remaining < 400*time.Millisecond || s.Remaining > 600*time.Millisecond {
+		t.Errorf("TestProgress error, remaining %v", s.Remaining)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond)
+	}))
+	defer ts.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	var body string
+	metricsTask := &Task{
+		Duration:      1500 * time.Millisecond,
+		Concurrent:    2,
+		MetricsAddr:   addr,
+		ReportHandler: func(results []*Result, totalTime time.Duration) {},
+		OnProgress: func(snapshot Snapshot) {
+			res, err := http.Get("http://" + addr + "/metrics")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			b, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			body = string(b)
+		},
+	}
+	_, err = metricsTask.Run(&RequestConfig{
+		URLStr: ts.URL,
+		Method: "GET",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf(`stress_requests_total{url="%s",method="GET",code="200"}`, ts.URL)
+	if !strings.Contains(body, want) || !strings.Contains(body, "stress_transaction_duration_seconds_count") {
+		t.Errorf("TestMetrics error, metrics:\n%s", body)
+	}
 }
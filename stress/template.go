@@ -0,0 +1,168 @@
+package stress
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	mrand "math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+type (
+	// requestTemplate is the templates of the URL, the header values and the body
+	// of a request, only the parts that contain "{{" are templates.
+	requestTemplate struct {
+		url    *template.Template
+		header map[string][]*template.Template
+		body   *template.Template
+	}
+	// templateData is the data of the templates, such as {{.Share.token}} or {{.Index}}.
+	templateData struct {
+		// Share is the container shared in the current transaction.
+		Share Share
+		// VU is the virtual user of the transaction, such as {{.VU.Store.token}}.
+		VU *VU
+		// GoRoutineNo is the current executed goroutine serial number.
+		GoRoutineNo int
+		// Index is current executed index.
+		Index int
+	}
+)
+
+var (
+	templateRand   = mrand.New(mrand.NewSource(time.Now().UnixNano()))
+	templateRandMx sync.Mutex
+)
+
+// templateFuncs is the functions of the templates.
+var templateFuncs = template.FuncMap{
+	// randInt returns a random number between min and max, inclusive.
+	"randInt": func(min, max int) int {
+		if max <= min {
+			return min
+		}
+		templateRandMx.Lock()
+		defer templateRandMx.Unlock()
+		return min + templateRand.Intn(max-min+1)
+	},
+	// randString returns a random alphanumeric string of n characters.
+	"randString": func(n int) string {
+		const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+		b := make([]byte, n)
+		templateRandMx.Lock()
+		defer templateRandMx.Unlock()
+		for i := range b {
+			b[i] = letters[templateRand.Intn(len(letters))]
+		}
+		return string(b)
+	},
+	// uuid returns a random (version 4) UUID.
+	"uuid": func() (string, error) {
+		var b [16]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return "", err
+		}
+		b[6] = b[6]&0x0f | 0x40
+		b[8] = b[8]&0x3f | 0x80
+		return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+	},
+	// timestamp returns the Unix time in seconds.
+	"timestamp": func() int64 {
+		return time.Now().Unix()
+	},
+	// timestampMs returns the Unix time in milliseconds.
+	"timestampMs": func() int64 {
+		return time.Now().UnixNano() / int64(time.Millisecond)
+	},
+	// now returns the current time in the layout of the time package, such as "2006-01-02T15:04:05Z07:00".
+	"now": func(layout string) string {
+		return time.Now().Format(layout)
+	},
+}
+
+// isTemplate reports whether s contains a template action.
+func isTemplate(s string) bool {
+	return strings.Contains(s, "{{")
+}
+
+// newRequestTemplate parses the templates of the request, it returns nil if there are none.
+func newRequestTemplate(config *RequestConfig) (*requestTemplate, error) {
+	rt := &requestTemplate{}
+	var found bool
+	parse := func(name, text string) (*template.Template, error) {
+		found = true
+		return template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	}
+	var err error
+	if isTemplate(config.URLStr) {
+		if rt.url, err = parse("url", config.URLStr); err != nil {
+			return nil, err
+		}
+	}
+	for k, values := range config.Header {
+		for i, v := range values {
+			if !isTemplate(v) {
+				continue
+			}
+			if rt.header == nil {
+				rt.header = make(map[string][]*template.Template)
+			}
+			if rt.header[k] == nil {
+				rt.header[k] = make([]*template.Template, len(values))
+			}
+			if rt.header[k][i], err = parse(k, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if isTemplate(string(config.ReqBody)) {
+		if rt.body, err = parse("body", string(config.ReqBody)); err != nil {
+			return nil, err
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+	return rt, nil
+}
+
+// render sets the rendered URL, header values and body on req.
+func (rt *requestTemplate) render(req *http.Request, data *templateData) error {
+	var b bytes.Buffer
+	if rt.url != nil {
+		if err := rt.url.Execute(&b, data); err != nil {
+			return err
+		}
+		u, err := url.Parse(b.String())
+		if err != nil {
+			return err
+		}
+		req.URL = u
+	}
+	for k, templates := range rt.header {
+		for i, tmpl := range templates {
+			if tmpl == nil {
+				continue
+			}
+			b.Reset()
+			if err := tmpl.Execute(&b, data); err != nil {
+				return err
+			}
+			req.Header[k][i] = b.String()
+		}
+	}
+	if rt.body != nil {
+		var body bytes.Buffer
+		if err := rt.body.Execute(&body, data); err != nil {
+			return err
+		}
+		req.Body = ioutil.NopCloser(&body)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package stress
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// thresholdRegexp matches a threshold such as "p99<300ms", "errors<1%" or "rps>=800".
+var thresholdRegexp = regexp.MustCompile(`^\s*(p[\d.]+|avg|min|max|errors|rps|count)\s*(<=|>=|<|>)\s*([\d.]+)\s*(%|[a-zµ]*)\s*$`)
+
+// threshold is a parsed threshold, latencies are in seconds.
+type threshold struct {
+	expr   string
+	metric string
+	pct    float64
+	op     string
+	value  float64
+	rate   bool
+}
+
+// parseThreshold parses a threshold of a metric, an operator and a value, where the metric is
+// a latency (p99, p99.9, avg, min or max) compared with a duration such as 300ms,
+// errors compared with a count or a percentage such as 1%,
+// rps (per second) or count (of transactions or requests).
+func parseThreshold(expr string) (*threshold, error) {
+	match := thresholdRegexp.FindStringSubmatch(expr)
+	if match == nil {
+		return nil, fmt.Errorf("could not parse the provided threshold; input = %v", expr)
+	}
+	th := &threshold{expr: strings.TrimSpace(expr), metric: match[1], op: match[2]}
+	value, err := strconv.ParseFloat(match[3], 64)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse the provided threshold; input = %v", expr)
+	}
+	unit := match[4]
+	switch {
+	case strings.HasPrefix(th.metric, "p"):
+		th.pct, err = strconv.ParseFloat(th.metric[1:], 64)
+		if err != nil || th.pct <= 0 || th.pct > 100 {
+			return nil, fmt.Errorf("could not parse the provided threshold percentile; input = %v", expr)
+		}
+		th.metric = "p"
+		fallthrough
+	case th.metric == "avg" || th.metric == "min" || th.metric == "max":
+		if unit == "" {
+			unit = "s"
+		}
+		d, err := time.ParseDuration(match[3] + unit)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse the provided threshold duration; input = %v", expr)
+		}
+		value = d.Seconds()
+	case th.metric == "errors" && unit == "%":
+		th.rate = true
+	case unit != "":
+		return nil, fmt.Errorf("could not parse the provided threshold unit; input = %v", expr)
+	}
+	th.value = value
+	return th, nil
+}
+
+// parseThresholds parses the thresholds of a task or of a request.
+func parseThresholds(exprs []string) ([]*threshold, error) {
+	var ths []*threshold
+	for _, expr := range exprs {
+		th, err := parseThreshold(expr)
+		if err != nil {
+			return nil, err
+		}
+		ths = append(ths, th)
+	}
+	return ths, nil
+}
+
+// evaluate returns the result of the threshold for the latency h,
+// count transactions or requests of which errCount failed, in total seconds.
+// A latency threshold without latency, such as when all the requests failed, fails.
+func (th *threshold) evaluate(h *histogram, count, errCount int64, total float64) *ThresholdReport {
+	var v float64
+	switch th.metric {
+	case "p", "avg", "min", "max":
+		if h.count == 0 {
+			return &ThresholdReport{Threshold: th.expr, NoData: true}
+		}
+	}
+	switch th.metric {
+	case "p":
+		v = h.quantile(th.pct / 100).Seconds()
+	case "avg":
+		v = h.mean().Seconds()
+	case "min":
+		v = h.min.Seconds()
+	case "max":
+		v = h.max.Seconds()
+	case "errors":
+		v = float64(errCount)
+		if th.rate {
+			v = 0
+			if count > 0 {
+				v = float64(errCount) * 100 / float64(count)
+			}
+		}
+	case "rps":
+		if total > 0 {
+			v = float64(count) / total
+		}
+	case "count":
+		v = float64(count)
+	}
+	var passed bool
+	switch th.op {
+	case "<":
+		passed = v < th.value
+	case "<=":
+		passed = v <= th.value
+	case ">":
+		passed = v > th.value
+	case ">=":
+		passed = v >= th.value
+	}
+	return &ThresholdReport{Threshold: th.expr, Value: v, Passed: passed}
+}
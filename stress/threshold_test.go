@@ -0,0 +1,38 @@
+package stress
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThresholds(t *testing.T) {
+	var h histogram
+	for i := 1; i <= 100; i++ {
+		h.record(time.Duration(i) * time.Millisecond)
+	}
+	for expr, want := range map[string]bool{
+		"p99<300ms":    true,
+		"p99.9 < 0.05": false,
+		"avg<=51ms":    true,
+		"min>2ms":      false,
+		"max>=100ms":   true,
+		"errors<5%":    false,
+		"errors<=10":   true,
+		"rps>40":       true,
+		"count>=101":   false,
+	} {
+		th, err := parseThreshold(expr)
+		if err != nil {
+			t.Errorf("TestThresholds error, %s: %v", expr, err)
+			continue
+		}
+		if tr := th.evaluate(&h, 100, 5, 2); tr.Passed != want {
+			t.Errorf("TestThresholds error, %s passed is %v with %v", expr, tr.Passed, tr.Value)
+		}
+	}
+	for _, expr := range []string{"p99", "p0<1s", "p101<1s", "latency<1s", "rps>800/s", "errors<1x", "avg<1parsec"} {
+		if _, err := parseThreshold(expr); err == nil {
+			t.Errorf("TestThresholds error, %s passed parsing", expr)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package stress
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// defaultInterval is the interval of the time series if Task.Interval is not set.
+const defaultInterval = time.Second
+
+type (
+	// timeline is the aggregate of the transactions per interval of the task, by start time.
+	// Requesters record into their own bucket of the current interval, and merge
+	// it into the shared timeline when they move on to another interval.
+	timeline struct {
+		mx       sync.Mutex
+		interval time.Duration
+		buckets  []*timeBucket
+	}
+	// timeBucket is the aggregate of the transactions started in an interval.
+	timeBucket struct {
+		duration histogram
+		errCount int
+		steps    []*timeStep
+	}
+	// timeStep is the aggregate of the requests of a step started in an interval,
+	// the duration only counts the requests with a response.
+	timeStep struct {
+		requests int64
+		duration histogram
+		errCount int
+	}
+)
+
+func newTimeBucket(steps int) *timeBucket {
+	b := &timeBucket{steps: make([]*timeStep, steps)}
+	for i := range b.steps {
+		b.steps[i] = &timeStep{}
+	}
+	return b
+}
+
+// add records a transaction started in the interval of the bucket.
+func (b *timeBucket) add(result *Result, failed bool) {
+	b.duration.record(result.Duration)
+	if failed {
+		b.errCount++
+	}
+}
+
+// addStep records a request of the step started in the interval of the bucket.
+func (b *timeBucket) addStep(step int, res *ResultDetail) {
+	s := b.steps[step]
+	s.requests++
+	if res.Failed() {
+		s.errCount++
+	}
+	if res.Err == nil {
+		s.duration.record(res.Duration)
+	}
+}
+
+func (b *timeBucket) merge(o *timeBucket) {
+	b.duration.merge(&o.duration)
+	b.errCount += o.errCount
+	for i, step := range o.steps {
+		b.steps[i].requests += step.requests
+		b.steps[i].duration.merge(&step.duration)
+		b.steps[i].errCount += step.errCount
+	}
+}
+
+// index returns the interval of offset after the start of the task.
+func (l *timeline) index(offset time.Duration) int {
+	if offset < 0 {
+		return 0
+	}
+	return int(offset / l.interval)
+}
+
+// addStep records a request of the step in the interval i of the timeline,
+// for the requests started in another interval than their transaction.
+func (l *timeline) addStep(i, steps, step int, res *ResultDetail) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	for len(l.buckets) <= i {
+		l.buckets = append(l.buckets, nil)
+	}
+	if l.buckets[i] == nil {
+		l.buckets[i] = newTimeBucket(steps)
+	}
+	l.buckets[i].addStep(step, res)
+}
+
+// merge adds the bucket of the interval i to the timeline.
+func (l *timeline) merge(i int, b *timeBucket) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	for len(l.buckets) <= i {
+		l.buckets = append(l.buckets, nil)
+	}
+	if l.buckets[i] == nil {
+		l.buckets[i] = b
+		return
+	}
+	l.buckets[i].merge(b)
+}
+
+// newTimelinePoints summarizes the timeline, intervals without transactions are kept.
+func newTimelinePoints(l *timeline, steps int, percentiles []float64) []*TimelinePoint {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	var points []*TimelinePoint
+	for i, b := range l.buckets {
+		if b == nil {
+			b = newTimeBucket(steps)
+		}
+		point := &TimelinePoint{
+			Start:        (time.Duration(i) * l.interval).Seconds(),
+			Transactions: b.duration.count,
+			Errors:       b.errCount,
+			RPS:          float64(b.duration.count) / l.interval.Seconds(),
+			Latency:      newLatency(&b.duration, percentiles),
+		}
+		for _, step := range b.steps {
+			point.Steps = append(point.Steps, &TimelineStep{
+				Requests: step.requests,
+				Errors:   step.errCount,
+				RPS:      float64(step.requests) / l.interval.Seconds(),
+				Latency:  newLatency(&step.duration, percentiles),
+			})
+		}
+		points = append(points, point)
+	}
+	return points
+}
+
+// writeTimeSeries writes the timeline to timeseries.json or timeseries.csv in the output directory.
+func (r *Report) writeTimeSeries(format, output string) error {
+	if format == FormatJSON {
+		b, err := json.MarshalIndent(r.Timeline, "", "  ")
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(output, "timeseries.json"), b, 0644)
+	}
+	file, err := os.Create(filepath.Join(output, "timeseries.csv"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	fmt.Fprintf(w, "start,step,method,url,requests,errors,rps,average,fastest,slowest")
+	for _, p := range r.Config.Percentiles {
+		fmt.Fprintf(w, ",p%v", p)
+	}
+	fmt.Fprintf(w, "\n")
+	for _, point := range r.Timeline {
+		writeTimeSeriesRow(w, point.Start, "transaction", "", "", point.Transactions, point.Errors, point.RPS, point.Latency)
+		for i, step := range point.Steps {
+			config := r.Config.Requests[i]
+			writeTimeSeriesRow(w, point.Start, fmt.Sprint(i+1), config.Method, config.URL, step.Requests, step.Errors, step.RPS, step.Latency)
+		}
+	}
+	return w.Flush()
+}
+
+func writeTimeSeriesRow(w *bufio.Writer, start float64, step, method, url string, requests int64, errors int, rps float64, l *Latency) {
+	fmt.Fprintf(w, "%4.4f,%s,%s,%s,%d,%d,%4.4f,%4.4f,%4.4f,%4.4f", start, step, method, url, requests, errors, rps, l.Average, l.Fastest, l.Slowest)
+	for _, p := range l.Percentiles {
+		fmt.Fprintf(w, ",%4.4f", p.Value)
+	}
+	fmt.Fprintf(w, "\n")
+}
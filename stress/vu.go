@@ -0,0 +1,36 @@
+package stress
+
+import "net/http"
+
+// VU is a virtual user, a requester of the task that runs transactions one after another.
+type VU struct {
+	// No is the serial number of the requester, the same as Request.GoRoutineNo.
+	No int
+	// Data is the data returned by the Setup of the task.
+	Data interface{}
+	// Store is a container that persists across the transactions of the virtual user,
+	// unlike the Share of each transaction. It is only used by the virtual user.
+	Store Share
+
+	jar http.CookieJar
+}
+
+// startVU returns the virtual user of the requester no, started by OnStart.
+func (r *runner) startVU(no int) *VU {
+	vu := &VU{
+		No:    no,
+		Data:  r.data,
+		Store: make(Share),
+	}
+	if r.OnStart != nil {
+		r.OnStart(vu)
+	}
+	return vu
+}
+
+// stopVU ends the virtual user by OnStop, after its last transaction.
+func (r *runner) stopVU(vu *VU) {
+	if r.OnStop != nil {
+		r.OnStop(vu)
+	}
+}
@@ -1,6 +1,20 @@
 package main
 
-import "testing"
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	lbstress "github.com/wenjiax/stress/stress"
+)
 
 func TestParseValidHeaderFlag(t *testing.T) {
 	match, err := parseInputWithRegexp("X-Something: !Y10K:;(He@poverflow?)", headerRegexp)
@@ -80,3 +94,254 @@ func TestParseInvalidThinkTimeFlag(t *testing.T) {
 		t.Errorf("An invalid ThinkTime passed parsing")
 	}
 }
+
+func TestParseValidStagesFlag(t *testing.T) {
+	stages, err := parseStages("30s:10,2m:200/s,500ms:0")
+	if err != nil {
+		t.Errorf("Valid stages were not parsed correctly: %v", err.Error())
+		return
+	}
+	if len(stages) != 3 ||
+		stages[0].Duration != 30*time.Second || stages[0].Concurrent != 10 ||
+		stages[1].Duration != 2*time.Minute || stages[1].Rate != 200 ||
+		stages[2].Duration != 500*time.Millisecond || stages[2].Concurrent != 0 {
+		t.Errorf("Valid stages were not parsed correctly, parsed values: %v", stages)
+	}
+}
+
+func TestParseInvalidStagesFlag(t *testing.T) {
+	_, err := parseStages("30s:10,2x:abc")
+	if err == nil {
+		t.Errorf("Invalid stages passed parsing")
+	}
+}
+
+func TestParseValidPercentilesFlag(t *testing.T) {
+	percentiles, err := parsePercentiles("50, 99,99.9")
+	if err != nil {
+		t.Errorf("Valid percentiles were not parsed correctly: %v", err.Error())
+		return
+	}
+	if len(percentiles) != 3 || percentiles[0] != 50 || percentiles[1] != 99 || percentiles[2] != 99.9 {
+		t.Errorf("Valid percentiles were not parsed correctly, parsed values: %v", percentiles)
+	}
+}
+
+func TestParseInvalidPercentilesFlag(t *testing.T) {
+	_, err := parsePercentiles("50,p99")
+	if err == nil {
+		t.Errorf("Invalid percentiles passed parsing")
+	}
+}
+
+func TestParseValidScenario(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "body.txt"), []byte("a,b"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "scenario.json"), []byte(`{
+		"duration": "1m30s",
+		"concurrent": 5,
+		"timeout": 0,
+		"stages": [{"duration": 30, "rate": 10}],
+		"cookies": "user",
+		"header": {"Accept": "application/json"},
+		"steps": [
+			{"name": "login", "url": "http://127.0.0.1:8080/a", "method": "POST", "body": "x,y", "header": {"X-Step": "1"},
+			 "abortOnFailure": true,
+			 "extract": [{"name": "token", "from": "json", "expr": "$.token"}]},
+			{"url": "http://127.0.0.1:8080/b", "bodyFile": "body.txt", "timeout": 3, "h2": true,
+			 "repeat": 3, "skipIf": "{{not .Share.token}}", "until": "{{eq .Share.state \"done\"}}", "next": "{{if .Share.retry}}login{{end}}"}
+		]
+	}`), 0644)
+	s, err := loadScenario(filepath.Join(dir, "scenario.json"))
+	if err != nil {
+		t.Fatalf("A valid scenario was not parsed correctly: %v", err.Error())
+	}
+	task := &lbstress.Task{Number: 100, Concurrent: 10, Timeout: 20}
+	header := make(http.Header)
+	header.Set("X-Option", "1")
+	scenarios, err := s.apply(task, header)
+	if err != nil {
+		t.Fatalf("A valid scenario was not applied correctly: %v", err.Error())
+	}
+	if len(scenarios) != 1 {
+		t.Fatalf("A valid scenario was not applied correctly, %d scenarios", len(scenarios))
+	}
+	configs := scenarios[0].Configs
+	if task.Number != 0 || task.Concurrent != 5 || task.Duration != 90*time.Second || task.Timeout != 0 ||
+		len(task.Stages) != 1 || task.Stages[0].Duration != 30*time.Second || task.Stages[0].Rate != 10 || task.Cookies != lbstress.CookiesUser {
+		t.Errorf("A valid scenario was not applied correctly, task: %+v", task)
+	}
+	if len(configs) != 2 {
+		t.Fatalf("A valid scenario was not applied correctly, %d steps", len(configs))
+	}
+	if configs[0].Method != "POST" || string(configs[0].ReqBody) != "x,y" ||
+		configs[0].Header.Get("X-Step") != "1" || configs[0].Header.Get("Accept") != "application/json" ||
+		configs[0].Header.Get("X-Option") != "1" || configs[1].Header.Get("X-Step") != "" ||
+		len(configs[0].Extractors) != 1 || configs[0].Extractors[0].Source != lbstress.ExtractJSON {
+		t.Errorf("A valid scenario was not applied correctly, step 1: %+v", configs[0])
+	}
+	if configs[1].Method != "GET" || string(configs[1].ReqBody) != "a,b" || configs[1].Timeout != 3 || !configs[1].H2 {
+		t.Errorf("A valid scenario was not applied correctly, step 2: %+v", configs[1])
+	}
+	if configs[0].Name != "login" || !configs[0].AbortOnFailure || configs[1].Repeat != 3 ||
+		!configs[1].Skip(lbstress.Share{}) || configs[1].Skip(lbstress.Share{"token": "t"}) ||
+		!configs[1].Until(lbstress.Share{"state": "done"}) || configs[1].Until(lbstress.Share{}) ||
+		configs[1].Next(lbstress.Share{"retry": true}) != "login" || configs[1].Next(lbstress.Share{}) != "" {
+		t.Errorf("A valid scenario was not applied correctly, control flow: %+v, %+v", configs[0], configs[1])
+	}
+}
+
+func TestParseValidScenarioMix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "body.txt"), []byte("a,b"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "scenario.json"), []byte(`{
+		"duration": 60,
+		"scenarios": [
+			{"name": "browse", "weight": 70, "steps": [{"url": "http://127.0.0.1:8080/a"}]},
+			{"name": "checkout", "weight": 30, "steps": [
+				{"url": "http://127.0.0.1:8080/b", "method": "POST", "bodyFile": "body.txt"},
+				{"url": "http://127.0.0.1:8080/c"}
+			]}
+		]
+	}`), 0644)
+	s, err := loadScenario(filepath.Join(dir, "scenario.json"))
+	if err != nil {
+		t.Fatalf("A valid scenario mix was not parsed correctly: %v", err.Error())
+	}
+	scenarios, err := s.apply(&lbstress.Task{Concurrent: 10}, make(http.Header))
+	if err != nil {
+		t.Fatalf("A valid scenario mix was not applied correctly: %v", err.Error())
+	}
+	if len(scenarios) != 2 || scenarios[0].Name != "browse" || scenarios[0].Weight != 70 || len(scenarios[0].Configs) != 1 ||
+		scenarios[1].Name != "checkout" || len(scenarios[1].Configs) != 2 || string(scenarios[1].Configs[0].ReqBody) != "a,b" {
+		t.Errorf("A valid scenario mix was not applied correctly: %+v", scenarios)
+	}
+}
+
+func TestParseInvalidScenario(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for i, content := range []string{`{"steps": []}`, `{"duration": "1x", "steps": [{"url": "http://127.0.0.1"}]}`, `{`,
+		`{"scenarios": [{"name": "a", "steps": []}]}`,
+		`{"steps": [{"url": "http://127.0.0.1"}], "scenarios": [{"name": "a", "steps": [{"url": "http://127.0.0.1"}]}]}`} {
+		path := filepath.Join(dir, fmt.Sprintf("scenario%d.json", i))
+		ioutil.WriteFile(path, []byte(content), 0644)
+		if _, err := loadScenario(path); err == nil {
+			t.Errorf("An invalid scenario passed parsing: %s", content)
+		}
+	}
+	path := filepath.Join(dir, "scenario.yaml")
+	ioutil.WriteFile(path, []byte("steps: [\n"), 0644)
+	if _, err := loadScenario(path); err == nil {
+		t.Errorf("An invalid YAML scenario passed parsing")
+	}
+}
+
+func TestParseValidScenarioYAML(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "scenario.yaml")
+	ioutil.WriteFile(path, []byte(`duration: 1m30s
+concurrent: 5
+stages:
+  - duration: 30
+    rate: 10
+steps:
+  - name: login
+    url: http://127.0.0.1:8080/a
+    method: POST
+    extract:
+      - {name: token, from: json, expr: $.token}
+  - url: http://127.0.0.1:8080/b
+    repeat: 3
+    until: '{{eq .Share.state "done"}}'
+`), 0644)
+	s, err := loadScenario(path)
+	if err != nil {
+		t.Fatalf("A valid YAML scenario was not parsed correctly: %v", err.Error())
+	}
+	task := &lbstress.Task{Number: 100, Concurrent: 10}
+	scenarios, err := s.apply(task, make(http.Header))
+	if err != nil {
+		t.Fatalf("A valid YAML scenario was not applied correctly: %v", err.Error())
+	}
+	configs := scenarios[0].Configs
+	if task.Duration != 90*time.Second || task.Concurrent != 5 || len(task.Stages) != 1 || task.Stages[0].Duration != 30*time.Second {
+		t.Errorf("A valid YAML scenario was not applied correctly, task: %+v", task)
+	}
+	if len(configs) != 2 || configs[0].Name != "login" || configs[0].Method != "POST" || len(configs[0].Extractors) != 1 ||
+		configs[1].Repeat != 3 || !configs[1].Until(lbstress.Share{"state": "done"}) {
+		t.Errorf("A valid YAML scenario was not applied correctly, steps: %+v", configs)
+	}
+}
+
+func TestAgentPlan(t *testing.T) {
+	task := &lbstress.Task{Number: 50, Concurrent: 5, Feeder: &lbstress.Feeder{
+		Rows: []map[string]interface{}{{"a": 1}, {"a": 2}, {"a": 3}, {"a": 4}},
+		Mode: lbstress.FeedPartitioned,
+	}}
+	scenarios := []*lbstress.Scenario{{Configs: []*lbstress.RequestConfig{{URLStr: "http://127.0.0.1:8080", Method: "GET"}}}}
+	start := time.Now()
+	first, err := newAgentPlan(task, scenarios, false, 0, 2, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := newAgentPlan(task, scenarios, false, 1, 2, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Concurrent != 3 || first.Number != 30 || second.Concurrent != 2 || second.Number != 20 ||
+		len(first.Rows) != 2 || len(second.Rows) != 2 || second.Rows[0]["a"] != 3 {
+		t.Errorf("A task was not divided correctly: %+v, %+v", first, second)
+	}
+	if _, err := newAgentPlan(&lbstress.Task{Number: 10, Concurrent: 1}, scenarios, false, 0, 2, start); err == nil {
+		t.Errorf("A task with less concurrency than agents was divided")
+	}
+}
+
+func TestController(t *testing.T) {
+	var count int64
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&count, 1)
+	}))
+	defer target.Close()
+	var agents []string
+	for i := 0; i < 2; i++ {
+		agent := httptest.NewServer(newAgentHandler())
+		defer agent.Close()
+		agents = append(agents, strings.TrimPrefix(agent.URL, "http://"))
+	}
+
+	task := &lbstress.Task{Number: 40, Concurrent: 4, Format: lbstress.FormatJSON}
+	scenarios := []*lbstress.Scenario{{Configs: []*lbstress.RequestConfig{{
+		URLStr: target.URL,
+		Method: "GET",
+		Checks: []*lbstress.Check{{Type: "status", Expr: "2xx"}},
+	}}}}
+	result, err := runController(context.Background(), task, scenarios, false, agents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 40 || result.Report.Transactions != 40 || result.Report.Errors != 0 ||
+		result.Report.Steps[0].Checks[0].Passed != 40 {
+		t.Errorf("The results of the agents were not merged correctly, %d requests, report %+v", count, result.Report)
+	}
+
+	if _, err := runController(context.Background(), task, scenarios, false, []string{"127.0.0.1:1"}); err == nil {
+		t.Errorf("An unreachable agent did not fail the task")
+	}
+}